@@ -19,6 +19,60 @@ type RateLimiter interface {
 	Reset(now time.Time)
 }
 
+// RateReporter is optionally implemented by a RateLimiter that can report its current limits.
+type RateReporter interface {
+	// Rate returns the current number of requests / sec allowed.
+	Rate() float64
+	// MaxBurst returns how many requests may be reserved at once.
+	MaxBurst() int
+}
+
+// Hint is capacity a backend told us about directly, for example with a Retry-After header.  Zero fields are not
+// hinted.
+type Hint struct {
+	// Rate is the requests / sec the backend suggests
+	Rate float64
+	// PauseUntil asks for no requests at all until this time
+	PauseUntil time.Time
+}
+
+// RateHinter is optionally implemented by a RateLimiter that can take hints from a backend, which say more than
+// OnFailure can.
+type RateHinter interface {
+	// OnHint changes the limiter to follow a hint
+	OnHint(now time.Time, hint Hint)
+}
+
+// ApplyHint gives hint to r if it is a RateHinter, and returns if it was
+func ApplyHint(r RateLimiter, now time.Time, hint Hint) bool {
+	if h, ok := r.(RateHinter); ok {
+		h.OnHint(now, hint)
+		return true
+	}
+	return false
+}
+
+// DelayReserver is optionally implemented by a RateLimiter that can reserve a request that is allowed a little
+// later, instead of only now.
+type DelayReserver interface {
+	// ReserveWithin reserves a request if one is allowed within maxWait.  It returns how long the caller must wait
+	// before making the request.  Nothing is reserved if it returns false.
+	ReserveWithin(now time.Time, maxWait time.Duration) (time.Duration, bool)
+}
+
+// Reserver is optionally implemented by a RateLimiter backed by a *rate.Limiter, which can hand out reservations of
+// any number of requests like rate.Limiter.ReserveN.
+type Reserver interface {
+	// ReserveN reserves n requests at now.  The reservation is not OK if the requests can never be allowed.
+	ReserveN(now time.Time, n int) *rate.Reservation
+}
+
+// notOK returns a reservation that is not OK, for a Reserver that cannot allow requests.  A limiter with no burst
+// never allows a request.
+func notOK(now time.Time) *rate.Reservation {
+	return rate.NewLimiter(0, 0).ReserveN(now, 1)
+}
+
 // AIMD is https://en.wikipedia.org/wiki/Additive_increase/multiplicative_decrease
 // It is *NOT* thread safe
 type AIMD struct {
@@ -37,6 +91,8 @@ type AIMD struct {
 
 	// TODO: We may want to implement some of this ourselves.  Use and optimize later
 	l *rate.Limiter
+	// pausedUntil denies every request until this time
+	pausedUntil time.Time
 }
 
 // AIMDConstructor constructs rate limiters according to the given parameters.  See documentation for AIMD for
@@ -52,9 +108,20 @@ func AIMDConstructor(additiveIncrease float64, multiplicativeDecrease float64, i
 	}
 }
 
-// Reset the RateLimiter back to the initial rate and burst
+// DefaultRateLimiter constructs the rate limiter that ratehttp, rategrpc and ratesql use when none is configured.
+// It is a reasonable AIMD configuration.  That configuration happens to be AIMDConstructor(1, .5, 1000, 100) right
+// now.
+func DefaultRateLimiter() RateLimiter {
+	return AIMDConstructor(1, .5, 1000, 100)()
+}
+
+// Reset the RateLimiter back to the initial rate and burst.  A pause from OnHint that has not ended is kept, since
+// the backend asked for it.
 func (a *AIMD) Reset(now time.Time) {
 	a.l = rate.NewLimiter(rate.Limit(a.InitialRate), a.Burst)
+	if !now.Before(a.pausedUntil) {
+		a.pausedUntil = time.Time{}
+	}
 }
 
 func (a *AIMD) init(now time.Time) {
@@ -73,9 +140,52 @@ func (a *AIMD) OnFailure(now time.Time) {
 // you to reserve a request.
 func (a *AIMD) AttemptReserve(now time.Time) bool {
 	a.init(now)
+	if now.Before(a.pausedUntil) {
+		return false
+	}
 	return a.l.AllowN(now, 1)
 }
 
+// ReserveWithin reserves a request that the current rate allows within maxWait.  Nothing is reserved during a
+// pause hint.
+func (a *AIMD) ReserveWithin(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	a.init(now)
+	if now.Before(a.pausedUntil) {
+		return 0, false
+	}
+	r := a.l.ReserveN(now, 1)
+	if !r.OK() {
+		return 0, false
+	}
+	wait := r.DelayFrom(now)
+	if wait > maxWait {
+		r.CancelAt(now)
+		return 0, false
+	}
+	return wait, true
+}
+
+// ReserveN reserves n requests like rate.Limiter.ReserveN.  Nothing is reserved during a pause hint.
+func (a *AIMD) ReserveN(now time.Time, n int) *rate.Reservation {
+	a.init(now)
+	if now.Before(a.pausedUntil) {
+		return notOK(now)
+	}
+	return a.l.ReserveN(now, n)
+}
+
+// OnHint jumps to the hinted rate, and denies every request until the hinted pause is over.  Additive increase
+// continues from the hinted rate as usual.  Once a pause is over, at most Burst requests are allowed at once.
+func (a *AIMD) OnHint(now time.Time, hint Hint) {
+	a.init(now)
+	if hint.Rate > 0 {
+		a.l.SetLimitAt(now, rate.Limit(hint.Rate))
+	}
+	if hint.PauseUntil.After(a.pausedUntil) {
+		a.pausedUntil = hint.PauseUntil
+	}
+}
+
 // Rate returns the current rate.
 func (a *AIMD) Rate() float64 {
 	if a.l == nil {
@@ -87,6 +197,11 @@ func (a *AIMD) Rate() float64 {
 	return float64(a.l.Limit())
 }
 
+// MaxBurst returns Burst.
+func (a *AIMD) MaxBurst() int {
+	return a.Burst
+}
+
 // OnSuccess increases the reserved limit for this period.
 func (a *AIMD) OnSuccess(now time.Time) {
 	a.init(now)
@@ -94,3 +209,7 @@ func (a *AIMD) OnSuccess(now time.Time) {
 }
 
 var _ RateLimiter = &AIMD{}
+var _ RateReporter = &AIMD{}
+var _ RateHinter = &AIMD{}
+var _ DelayReserver = &AIMD{}
+var _ Reserver = &AIMD{}
@@ -45,6 +45,7 @@ func TestAIMDConstructor(t *testing.T) {
 	equalFloat(t, 10, r.InitialRate)
 	equalInt(t, 10, r.Burst)
 	equalFloat(t, 10, r.Rate())
+	equalInt(t, 10, r.MaxBurst())
 
 }
 
@@ -53,12 +54,16 @@ func TestAIMDempty(t *testing.T) {
 	equalFloat(t, math.Inf(1), a.Rate())
 }
 
+// constructor is the signature of AIMDConstructor, so every rate limiter learning like AIMD can be tested the same way
+type constructor func(additiveIncrease float64, multiplicativeDecrease float64, initialRate float64, burst int) func() RateLimiter
+
 func TestAIMDNormalRate(t *testing.T) {
-	a := AIMD{
-		// Allow burst item every half second
-		InitialRate: 2,
-		Burst:       1,
-	}
+	testNormalRate(t, AIMDConstructor)
+}
+
+func testNormalRate(t *testing.T, c constructor) {
+	// Allow burst item every half second
+	a := c(0, 0, 2, 1)()
 	now := time.Now()
 	for i := 0; i < 10; i++ {
 		expect(t, a.AttemptReserve(now), "expected to be able to reserve")
@@ -69,19 +74,18 @@ func TestAIMDNormalRate(t *testing.T) {
 }
 
 func TestAIMDFailures(t *testing.T) {
-	a := AIMD{
-		// Allow burst item every half second
-		InitialRate:            2,
-		Burst:                  1,
-		AdditiveIncrease:       .1,
-		MultiplicativeDecrease: .9,
-	}
+	testFailures(t, AIMDConstructor)
+}
+
+func testFailures(t *testing.T, c constructor) {
+	// Allow burst item every half second
+	a := c(.1, .9, 2, 1)()
 	now := time.Now()
 	a.Reset(now)
 	a.OnFailure(now)
 	a.OnFailure(now)
 	a.OnFailure(now)
-	equalFloat(t, a.Rate(), 2*.9*.9*.9)
+	equalFloat(t, a.(RateReporter).Rate(), 2*.9*.9*.9)
 }
 
 type RateLimitedService struct {
@@ -165,13 +169,14 @@ func TestAIMDLevelingOut(t *testing.T) {
 }
 
 func TestAIMDBurst(t *testing.T) {
-	a := AIMD{
-		InitialRate: 1,
-		Burst:       10,
-	}
+	testBurst(t, AIMDConstructor)
+}
+
+func testBurst(t *testing.T, c constructor) {
+	a := c(0, 0, 1, 10)()
 
 	now := time.Now()
-	for i := 0; i < a.Burst; i++ {
+	for i := 0; i < 10; i++ {
 		if !a.AttemptReserve(now) {
 			t.Errorf("expected burst at %d", i)
 		}
@@ -191,3 +196,68 @@ func TestAIMDBurst(t *testing.T) {
 		t.Error("expected to allow an item")
 	}
 }
+
+func TestAIMD_OnHint(t *testing.T) {
+	a := AIMD{
+		AdditiveIncrease:       1,
+		MultiplicativeDecrease: .5,
+		InitialRate:            100,
+		Burst:                  2,
+	}
+	now := time.Now()
+	a.OnHint(now, Hint{Rate: 5})
+	equalFloat(t, 5, a.Rate())
+	a.OnSuccess(now)
+	equalFloat(t, 6, a.Rate())
+
+	a.OnHint(now, Hint{PauseUntil: now.Add(time.Second)})
+	equalFloat(t, 6, a.Rate())
+	expect(t, !a.AttemptReserve(now), "expected no requests during a pause")
+	expect(t, !a.AttemptReserve(now.Add(time.Second-time.Nanosecond)), "expected no requests during a pause")
+	// An earlier pause does not shorten the current one
+	a.OnHint(now, Hint{PauseUntil: now.Add(time.Millisecond)})
+	expect(t, !a.AttemptReserve(now.Add(time.Millisecond*2)), "expected the longer pause to win")
+
+	now = now.Add(time.Second)
+	expect(t, a.AttemptReserve(now), "expected requests once the pause is over")
+	expect(t, a.AttemptReserve(now), "expected requests once the pause is over")
+	expect(t, !a.AttemptReserve(now), "expected at most a burst once the pause is over")
+	a.OnSuccess(now)
+	equalFloat(t, 7, a.Rate())
+
+	a.OnHint(now, Hint{PauseUntil: now.Add(time.Hour)})
+	a.Reset(now)
+	expect(t, !a.AttemptReserve(now), "expected reset to keep a pause")
+	equalFloat(t, 100, a.Rate())
+	now = now.Add(time.Hour)
+	expect(t, a.AttemptReserve(now), "expected requests once the pause is over")
+	a.Reset(now)
+	expect(t, a.AttemptReserve(now) && a.AttemptReserve(now), "expected reset to forget an ended pause")
+}
+
+func TestApplyHint(t *testing.T) {
+	a := &AIMD{InitialRate: 10, Burst: 1}
+	expect(t, ApplyHint(a, time.Now(), Hint{Rate: 1}), "expected AIMD to take hints")
+	equalFloat(t, 1, a.Rate())
+	expect(t, !ApplyHint(neverLimits{}, time.Now(), Hint{Rate: 1}), "expected limiters without hints to be skipped")
+}
+
+func TestAIMD_ReserveWithin(t *testing.T) {
+	a := AIMD{
+		InitialRate: 10,
+		Burst:       1,
+	}
+	now := time.Now()
+	wait, ok := a.ReserveWithin(now, 0)
+	expect(t, ok && wait == 0, "expected the burst to be free")
+	wait, ok = a.ReserveWithin(now, time.Second)
+	expect(t, ok && wait == time.Millisecond*100, "expected to wait for the next token")
+	_, ok = a.ReserveWithin(now, time.Millisecond*150)
+	expect(t, !ok, "expected the third token to be too far away")
+	wait, ok = a.ReserveWithin(now, time.Millisecond*200)
+	expect(t, ok && wait == time.Millisecond*200, "expected a refused reservation not to be kept")
+
+	a.OnHint(now, Hint{PauseUntil: now.Add(time.Hour)})
+	_, ok = a.ReserveWithin(now.Add(time.Second), time.Hour*2)
+	expect(t, !ok, "expected no reservations during a pause")
+}
@@ -0,0 +1,78 @@
+package aimdcloser
+
+import (
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Batch is the results of many requests, reported at once
+type Batch struct {
+	// Successes is how many requests succeeded
+	Successes int
+	// Failures is how many requests failed
+	Failures int
+	// Timeouts is how many requests timed out.  Rate limiters count them as failures.
+	Timeouts int
+}
+
+// BatchObserver is optionally implemented by a RateLimiter that can take a Batch in one step.  The result must be
+// the same as every success, then every failure and timeout, reported one at a time.
+type BatchObserver interface {
+	// OnBatch reports every result of a batch
+	OnBatch(now time.Time, batch Batch)
+}
+
+// ApplyBatch reports batch to r in one call if it is a BatchObserver.  Otherwise it reports every success, then
+// every failure and timeout, one at a time.  Successes go first since the order inside a batch is unknown, and this
+// order never leaves the rate higher than any other.
+func ApplyBatch(r RateLimiter, now time.Time, batch Batch) {
+	if b, ok := r.(BatchObserver); ok {
+		b.OnBatch(now, batch)
+		return
+	}
+	applyBatchEach(r, now, batch)
+}
+
+func applyBatchEach(r RateLimiter, now time.Time, batch Batch) {
+	for i := 0; i < batch.Successes; i++ {
+		r.OnSuccess(now)
+	}
+	for i := 0; i < batch.Failures+batch.Timeouts; i++ {
+		r.OnFailure(now)
+	}
+}
+
+// OnBatch adds AdditiveIncrease once per success, then multiplies by MultiplicativeDecrease once per failure and
+// timeout, in a single step.
+func (a *AIMD) OnBatch(now time.Time, batch Batch) {
+	a.init(now)
+	limit := float64(a.l.Limit()) + float64(batch.Successes)*a.AdditiveIncrease
+	if failures := batch.Failures + batch.Timeouts; failures > 0 {
+		limit *= math.Pow(a.MultiplicativeDecrease, float64(failures))
+	}
+	a.l.SetLimitAt(now, rate.Limit(limit))
+}
+
+// OnBatch reports each result of batch, so each one gets its own jitter
+func (j *JitteredAIMD) OnBatch(now time.Time, batch Batch) {
+	applyBatchEach(j, now, batch)
+}
+
+// OnBatch reports each result of batch, since each decrease depends on the error rate at that point
+func (e *ErrorRateAIMD) OnBatch(now time.Time, batch Batch) {
+	applyBatchEach(e, now, batch)
+}
+
+// OnBatch reports batch to RateLimiter while holding the lock once
+func (s *SyncRateLimiter) OnBatch(now time.Time, batch Batch) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ApplyBatch(s.RateLimiter, now, batch)
+}
+
+var _ BatchObserver = &AIMD{}
+var _ BatchObserver = &JitteredAIMD{}
+var _ BatchObserver = &ErrorRateAIMD{}
+var _ BatchObserver = &SyncRateLimiter{}
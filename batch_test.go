@@ -0,0 +1,72 @@
+package aimdcloser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAIMD_OnBatch(t *testing.T) {
+	batches := []Batch{
+		{},
+		{Successes: 10},
+		{Failures: 3},
+		{Successes: 5, Failures: 2, Timeouts: 1},
+		{Timeouts: 4},
+	}
+	now := time.Now()
+	for _, b := range batches {
+		single := &AIMD{AdditiveIncrease: .7, MultiplicativeDecrease: .9, InitialRate: 100, Burst: 10}
+		batched := &AIMD{AdditiveIncrease: .7, MultiplicativeDecrease: .9, InitialRate: 100, Burst: 10}
+		applyBatchEach(single, now, b)
+		batched.OnBatch(now, b)
+		equalFloat(t, single.Rate(), batched.Rate())
+	}
+}
+
+func TestApplyBatch(t *testing.T) {
+	now := time.Now()
+	b := Batch{Successes: 3, Failures: 1, Timeouts: 1}
+	type pair struct {
+		single  RateLimiter
+		batched RateLimiter
+	}
+	pairs := []pair{
+		{AIMDConstructor(1, .5, 10, 1)(), AIMDConstructor(1, .5, 10, 1)()},
+		{ErrorRateAIMDConstructor(1, .5, 10, 1, time.Second, .1)(), ErrorRateAIMDConstructor(1, .5, 10, 1, time.Second, .1)()},
+		{&SyncRateLimiter{RateLimiter: &AIMD{AdditiveIncrease: 1, MultiplicativeDecrease: .5, InitialRate: 10}}, &SyncRateLimiter{RateLimiter: &AIMD{AdditiveIncrease: 1, MultiplicativeDecrease: .5, InitialRate: 10}}},
+		{HierarchyConstructor(&SyncRateLimiter{RateLimiter: &AIMD{InitialRate: 10}}, AIMDConstructor(1, .5, 10, 1), .5)(), HierarchyConstructor(&SyncRateLimiter{RateLimiter: &AIMD{InitialRate: 10}}, AIMDConstructor(1, .5, 10, 1), .5)()},
+	}
+	for _, p := range pairs {
+		applyBatchEach(p.single, now, b)
+		ApplyBatch(p.batched, now, b)
+		equalFloat(t, p.single.(RateReporter).Rate(), p.batched.(RateReporter).Rate())
+	}
+	// Successes first never leaves the rate higher than failures first
+	a := &AIMD{AdditiveIncrease: 1, MultiplicativeDecrease: .5, InitialRate: 10}
+	a.OnFailure(now)
+	a.OnFailure(now)
+	a.OnSuccess(now)
+	a.OnSuccess(now)
+	a.OnSuccess(now)
+	batched := &AIMD{AdditiveIncrease: 1, MultiplicativeDecrease: .5, InitialRate: 10}
+	batched.OnBatch(now, Batch{Successes: 3, Failures: 2})
+	expect(t, batched.Rate() <= a.Rate(), "expected successes first to be conservative")
+}
+
+func BenchmarkAIMD_OnSuccess_100(b *testing.B) {
+	a := AIMD{AdditiveIncrease: .1, MultiplicativeDecrease: .9, InitialRate: 1000, Burst: 10}
+	now := time.Now()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 100; j++ {
+			a.OnSuccess(now)
+		}
+	}
+}
+
+func BenchmarkAIMD_OnBatch_100(b *testing.B) {
+	a := AIMD{AdditiveIncrease: .1, MultiplicativeDecrease: .9, InitialRate: 1000, Burst: 10}
+	now := time.Now()
+	for i := 0; i < b.N; i++ {
+		a.OnBatch(now, Batch{Successes: 100})
+	}
+}
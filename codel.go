@@ -0,0 +1,177 @@
+package aimdcloser
+
+import (
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// CoDel is an AIMD whose requests may queue briefly for admission, with the queue kept short by Controlled Delay
+// (https://queue.acm.org/detail.cfm?id=2209336).  A request's sojourn time is how long it waits in the queue before
+// the rate admits it.  Once sojourn times have stayed above Target for a whole Interval, CoDel starts dropping
+// requests, more often the longer the queue stays, at Interval divided by the square root of how many it dropped.
+// Dropping stops as soon as a request's sojourn time is below Target.
+//
+// Requests queue through ReserveWithin, so a ratecloser.Closer with a MaxWait can use it through AllowBy and Wait.
+// Only requests that are kept count toward sojourn times, so AttemptReserve, which never queues, only ever sees an
+// empty queue.
+// It is *NOT* thread safe
+type CoDel struct {
+	AIMD
+	// Target is the sojourn time a queue may keep.  Defaults to 5ms.
+	Target time.Duration
+	// Interval is how long sojourn times may stay above Target before dropping starts.  Defaults to 100ms.
+	Interval time.Duration
+
+	// firstAboveTime is when sojourn times will have been above Target for Interval, or zero if they are not above
+	firstAboveTime time.Time
+	// dropNext is when the next request is dropped while dropping
+	dropNext  time.Time
+	count     int
+	lastCount int
+	dropping  bool
+	drops     int64
+}
+
+const (
+	defaultCoDelTarget   = time.Millisecond * 5
+	defaultCoDelInterval = time.Millisecond * 100
+)
+
+// CoDelConstructor constructs rate limiters according to the given parameters.  See documentation for AIMD and CoDel
+// for what each parameter means.
+func CoDelConstructor(additiveIncrease float64, multiplicativeDecrease float64, initialRate float64, burst int, target time.Duration, interval time.Duration) func() RateLimiter {
+	return func() RateLimiter {
+		return &CoDel{
+			AIMD: AIMD{
+				AdditiveIncrease:       additiveIncrease,
+				MultiplicativeDecrease: multiplicativeDecrease,
+				InitialRate:            initialRate,
+				Burst:                  burst,
+			},
+			Target:   target,
+			Interval: interval,
+		}
+	}
+}
+
+func (c *CoDel) target() time.Duration {
+	if c.Target <= 0 {
+		return defaultCoDelTarget
+	}
+	return c.Target
+}
+
+func (c *CoDel) interval() time.Duration {
+	if c.Interval <= 0 {
+		return defaultCoDelInterval
+	}
+	return c.Interval
+}
+
+// controlLaw returns when to drop next, Interval/sqrt(count) after t
+func (c *CoDel) controlLaw(t time.Time) time.Time {
+	return t.Add(time.Duration(float64(c.interval()) / math.Sqrt(float64(c.count))))
+}
+
+// drop records the sojourn time of a request at now, and returns if it should be dropped
+func (c *CoDel) drop(now time.Time, sojourn time.Duration) bool {
+	okToDrop := false
+	if sojourn < c.target() {
+		c.firstAboveTime = time.Time{}
+	} else if c.firstAboveTime.IsZero() {
+		c.firstAboveTime = now.Add(c.interval())
+	} else if !now.Before(c.firstAboveTime) {
+		okToDrop = true
+	}
+	if c.dropping {
+		if !okToDrop {
+			c.dropping = false
+			return false
+		}
+		if now.Before(c.dropNext) {
+			return false
+		}
+		c.count++
+		c.dropNext = c.controlLaw(c.dropNext)
+		c.drops++
+		return true
+	}
+	if !okToDrop {
+		return false
+	}
+	c.dropping = true
+	// Dropping again soon after it stopped starts near the drop rate it stopped at
+	delta := c.count - c.lastCount
+	c.count = 1
+	if delta > 1 && now.Sub(c.dropNext) < 16*c.interval() {
+		c.count = delta
+	}
+	c.dropNext = c.controlLaw(now)
+	c.lastCount = c.count
+	c.drops++
+	return true
+}
+
+// reserve reserves n requests that wait at most maxWait, unless Controlled Delay drops them.  Requests refused for
+// waiting too long never join the queue, so their wait is not a sojourn time.
+func (c *CoDel) reserve(now time.Time, n int, maxWait time.Duration) *rate.Reservation {
+	r := c.AIMD.ReserveN(now, n)
+	if !r.OK() {
+		return r
+	}
+	wait := r.DelayFrom(now)
+	if wait > maxWait || c.drop(now, wait) {
+		r.CancelAt(now)
+		return notOK(now)
+	}
+	return r
+}
+
+// ReserveN reserves n requests like rate.Limiter.ReserveN, unless Controlled Delay drops them
+func (c *CoDel) ReserveN(now time.Time, n int) *rate.Reservation {
+	return c.reserve(now, n, rate.InfDuration)
+}
+
+// ReserveWithin queues a request for at most maxWait, unless Controlled Delay drops it.  It returns the request's
+// sojourn time.
+func (c *CoDel) ReserveWithin(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	r := c.reserve(now, 1, maxWait)
+	if !r.OK() {
+		return 0, false
+	}
+	return r.DelayFrom(now), true
+}
+
+// AttemptReserve tries to reserve a request without queueing
+func (c *CoDel) AttemptReserve(now time.Time) bool {
+	_, ok := c.ReserveWithin(now, 0)
+	return ok
+}
+
+// Reset the rate back to InitialRate, and stop dropping
+func (c *CoDel) Reset(now time.Time) {
+	c.AIMD.Reset(now)
+	c.firstAboveTime = time.Time{}
+	c.dropNext = time.Time{}
+	c.count = 0
+	c.lastCount = 0
+	c.dropping = false
+}
+
+// Dropping returns if Controlled Delay is dropping requests
+func (c *CoDel) Dropping() bool {
+	return c.dropping
+}
+
+// Drops returns how many requests Controlled Delay has dropped
+func (c *CoDel) Drops() int64 {
+	return c.drops
+}
+
+var _ RateLimiter = &CoDel{}
+var _ RateReporter = &CoDel{}
+var _ RateHinter = &CoDel{}
+var _ DelayReserver = &CoDel{}
+var _ Reserver = &CoDel{}
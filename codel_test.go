@@ -0,0 +1,104 @@
+package aimdcloser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCoDelConstructor(t *testing.T) {
+	c := CoDelConstructor(1, .5, 100, 10, time.Millisecond, time.Second)().(*CoDel)
+	equalFloat(t, 100, c.Rate())
+	equalInt(t, 10, c.MaxBurst())
+	expect(t, c.Target == time.Millisecond && c.Interval == time.Second, "expected target and interval to be set")
+	expect(t, (&CoDel{}).target() == defaultCoDelTarget, "expected a default target")
+	expect(t, (&CoDel{}).interval() == defaultCoDelInterval, "expected a default interval")
+}
+
+// offer sends a request every arrival from start until end, each willing to queue for up to maxWait, and returns
+// the mean sojourn time of admitted requests after settle, with how many were admitted
+func offer(r DelayReserver, start time.Time, end time.Time, settle time.Time, arrival time.Duration, maxWait time.Duration) (time.Duration, int) {
+	var total time.Duration
+	admitted := 0
+	for now := start; now.Before(end); now = now.Add(arrival) {
+		wait, ok := r.ReserveWithin(now, maxWait)
+		if ok && !now.Before(settle) {
+			total += wait
+			admitted++
+		}
+	}
+	if admitted == 0 {
+		return 0, 0
+	}
+	return total / time.Duration(admitted), admitted
+}
+
+func TestCoDel_keepsQueueShort(t *testing.T) {
+	start := time.Unix(1000, 0)
+	end := start.Add(time.Second * 10)
+	settle := start.Add(time.Second * 5)
+	// Requests arrive at 200 / sec, twice the rate of 100 / sec
+	plain := &AIMD{InitialRate: 100, Burst: 1}
+	plainSojourn, plainAdmitted := offer(plain, start, end, settle, time.Millisecond*5, time.Second)
+	c := &CoDel{AIMD: AIMD{InitialRate: 100, Burst: 1}, Target: time.Millisecond * 5, Interval: time.Millisecond * 100}
+	codelSojourn, codelAdmitted := offer(c, start, end, settle, time.Millisecond*5, time.Second)
+	t.Logf("plain: %s over %d, codel: %s over %d, %d drops", plainSojourn, plainAdmitted, codelSojourn, codelAdmitted, c.Drops())
+	expect(t, plainSojourn > time.Millisecond*900, "expected a standing queue without CoDel")
+	// Arrivals do not slow down when dropped, so the queue is not kept at Target, but it stays well below MaxWait
+	expect(t, codelSojourn < plainSojourn/3, "expected CoDel to keep sojourn times short")
+	expect(t, codelAdmitted >= plainAdmitted*9/10, "expected CoDel to keep admitting at about the rate")
+	expect(t, c.Dropping(), "expected to still be dropping under overload")
+}
+
+func TestCoDel_dropsMoreOften(t *testing.T) {
+	c := &CoDel{AIMD: AIMD{InitialRate: 1, Burst: 1}, Target: time.Millisecond * 5, Interval: time.Millisecond * 100}
+	now := time.Unix(1000, 0)
+	var drops []time.Time
+	// Every request waits far longer than Target
+	for i := 0; i < 2000; i++ {
+		now = now.Add(time.Millisecond)
+		if r := c.ReserveN(now, 1); !r.OK() {
+			drops = append(drops, now)
+		}
+	}
+	expect(t, len(drops) > 4, "expected drops")
+	expect(t, drops[0].Sub(time.Unix(1000, 0)) >= time.Millisecond*100, "expected no drops before a whole interval")
+	// Drops land on the first request after they are due, so allow one arrival of slack
+	for i := 2; i < len(drops); i++ {
+		expect(t, drops[i].Sub(drops[i-1]) <= drops[i-1].Sub(drops[i-2])+time.Millisecond, "expected drops to come more often")
+	}
+	expect(t, drops[1].Sub(drops[0]) > drops[len(drops)-1].Sub(drops[len(drops)-2]), "expected drops to come more often")
+}
+
+func TestCoDel_stopsDropping(t *testing.T) {
+	c := &CoDel{AIMD: AIMD{InitialRate: 100, Burst: 1}}
+	start := time.Unix(1000, 0)
+	offer(c, start, start.Add(time.Second), start, time.Millisecond*5, time.Second)
+	expect(t, c.Dropping(), "expected to be dropping under overload")
+	drops := c.Drops()
+	// Once arrivals slow below the rate, the queue drains and dropping stops
+	later := start.Add(time.Second * 5)
+	_, admitted := offer(c, later, later.Add(time.Second), later, time.Millisecond*20, time.Second)
+	equalInt(t, 50, admitted)
+	expect(t, !c.Dropping(), "expected dropping to stop once the queue is short")
+	expect(t, c.Drops() == drops, "expected no more drops")
+
+	offer(c, later.Add(time.Second), later.Add(time.Second*2), later, time.Millisecond*5, time.Second)
+	expect(t, c.Dropping(), "expected to be dropping under overload")
+	c.Reset(later)
+	expect(t, !c.Dropping(), "expected reset to stop dropping")
+	expect(t, c.AttemptReserve(later), "expected reset to refill the burst")
+}
+
+func TestCoDel_onlyKeptRequestsCount(t *testing.T) {
+	// A token every second leaves a wait far above Target for far longer than Interval
+	c := &CoDel{AIMD: AIMD{InitialRate: 1, Burst: 1}}
+	start := time.Unix(1000, 0)
+	// Requests that would wait longer than they may are refused without queueing, so there is no queue to shorten
+	_, admitted := offer(c, start, start.Add(time.Second*2), start, time.Millisecond, time.Millisecond)
+	expect(t, admitted > 0, "expected requests to be admitted as the rate allows")
+	expect(t, c.Drops() == 0 && !c.Dropping(), "expected refused requests not to count as sojourn times")
+	for now := start.Add(time.Second * 2); now.Before(start.Add(time.Second * 4)); now = now.Add(time.Millisecond) {
+		c.AttemptReserve(now)
+	}
+	expect(t, c.Drops() == 0 && !c.Dropping(), "expected AttemptReserve not to count how long it would have waited")
+}
@@ -0,0 +1,150 @@
+package aimdcloser
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// ConcurrencyLimiter limits how many requests are in flight at once, for backends where concurrency matters more
+// than rate.  It is the in flight counterpart of RateLimiter.
+type ConcurrencyLimiter interface {
+	// Acquire returns a token if another request may be in flight at now, and false if not.  Release the token once
+	// the request finishes.
+	Acquire(now time.Time) (ConcurrencyToken, bool)
+	// Reset the limiter back to its initial limit, forgetting every request in flight
+	Reset(now time.Time)
+}
+
+// ConcurrencyToken is a request in flight
+type ConcurrencyToken interface {
+	// Release ends the request, with how it went and how long it took.  Only the first Release of a token counts.
+	Release(now time.Time, outcome Outcome, latency time.Duration)
+}
+
+// AIMDConcurrency is an AIMD over how many requests may be in flight.  Each success adds AdditiveIncrease divided by
+// the limit, so the limit grows by AdditiveIncrease for every limit's worth of successes, about once a round trip.
+// Each failure multiplies the limit by MultiplicativeDecrease.
+// It is safe for concurrent use, since tokens are usually released from other goroutines.
+type AIMDConcurrency struct {
+	// How many requests in flight are added to the limit per limit's worth of successes
+	AdditiveIncrease float64
+	// What the limit is multiplied by on a failure
+	MultiplicativeDecrease float64
+	// InitialLimit is how many requests may be in flight when reset
+	InitialLimit float64
+	// MinLimit is the smallest the limit may go.  Defaults to 1.
+	MinLimit float64
+	// MaxLimit is the largest the limit may go.  Zero has no limit.
+	MaxLimit float64
+	// LatencyThreshold, if set, counts successes slower than it as failures
+	LatencyThreshold time.Duration
+
+	limit    float64
+	inFlight int
+	// generation changes on Reset, so tokens from before are not released twice
+	generation uint64
+	started    bool
+	mu         sync.Mutex
+}
+
+// AIMDConcurrencyConstructor constructs concurrency limiters according to the given parameters.  See documentation
+// for AIMDConcurrency for what each parameter means.
+func AIMDConcurrencyConstructor(additiveIncrease float64, multiplicativeDecrease float64, initialLimit float64) func() ConcurrencyLimiter {
+	return func() ConcurrencyLimiter {
+		return &AIMDConcurrency{
+			AdditiveIncrease:       additiveIncrease,
+			MultiplicativeDecrease: multiplicativeDecrease,
+			InitialLimit:           initialLimit,
+		}
+	}
+}
+
+type aimdConcurrencyToken struct {
+	a          *AIMDConcurrency
+	generation uint64
+	released   bool
+}
+
+// Release ends the request, changing the limit of AIMDConcurrency by outcome
+func (t *aimdConcurrencyToken) Release(now time.Time, outcome Outcome, latency time.Duration) {
+	t.a.mu.Lock()
+	defer t.a.mu.Unlock()
+	if t.released || t.generation != t.a.generation {
+		return
+	}
+	t.released = true
+	t.a.inFlight--
+	if outcome == Success && t.a.LatencyThreshold > 0 && latency > t.a.LatencyThreshold {
+		outcome = Failure
+	}
+	switch outcome {
+	case Success:
+		t.a.setLimit(t.a.limit + t.a.AdditiveIncrease/t.a.limit)
+	case Failure:
+		t.a.setLimit(t.a.limit * t.a.MultiplicativeDecrease)
+	}
+}
+
+func (a *AIMDConcurrency) setLimit(limit float64) {
+	minLimit := a.MinLimit
+	if minLimit <= 0 {
+		minLimit = 1
+	}
+	if limit < minLimit {
+		limit = minLimit
+	}
+	if a.MaxLimit > 0 && limit > a.MaxLimit {
+		limit = a.MaxLimit
+	}
+	a.limit = limit
+}
+
+func (a *AIMDConcurrency) init() {
+	if !a.started {
+		a.reset()
+	}
+}
+
+func (a *AIMDConcurrency) reset() {
+	a.setLimit(a.InitialLimit)
+	a.inFlight = 0
+	a.generation++
+	a.started = true
+}
+
+// Reset the limit back to InitialLimit.  Tokens acquired before are not counted as in flight anymore.
+func (a *AIMDConcurrency) Reset(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.reset()
+}
+
+// Acquire returns a token if fewer requests than the limit are in flight
+func (a *AIMDConcurrency) Acquire(now time.Time) (ConcurrencyToken, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.init()
+	if float64(a.inFlight) >= math.Floor(a.limit) {
+		return nil, false
+	}
+	a.inFlight++
+	return &aimdConcurrencyToken{a: a, generation: a.generation}, true
+}
+
+// Limit returns how many requests may be in flight
+func (a *AIMDConcurrency) Limit() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.init()
+	return a.limit
+}
+
+// InFlight returns how many acquired tokens are not released yet
+func (a *AIMDConcurrency) InFlight() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.inFlight
+}
+
+var _ ConcurrencyLimiter = &AIMDConcurrency{}
@@ -0,0 +1,108 @@
+package aimdcloser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAIMDConcurrencyConstructor(t *testing.T) {
+	a := AIMDConcurrencyConstructor(1, .5, 4)().(*AIMDConcurrency)
+	equalFloat(t, 1, a.AdditiveIncrease)
+	equalFloat(t, .5, a.MultiplicativeDecrease)
+	equalFloat(t, 4, a.Limit())
+	equalInt(t, 0, a.InFlight())
+}
+
+func TestAIMDConcurrency_AcquireRelease(t *testing.T) {
+	now := time.Now()
+	a := &AIMDConcurrency{AdditiveIncrease: 2, MultiplicativeDecrease: .5, InitialLimit: 2}
+	first, ok := a.Acquire(now)
+	expect(t, ok, "expected a token under the limit")
+	second, ok := a.Acquire(now)
+	expect(t, ok, "expected a token under the limit")
+	_, ok = a.Acquire(now)
+	expect(t, !ok, "expected no token at the limit")
+	equalInt(t, 2, a.InFlight())
+
+	first.Release(now, Success, time.Millisecond)
+	equalFloat(t, 3, a.Limit())
+	first.Release(now, Failure, time.Millisecond)
+	equalFloat(t, 3, a.Limit())
+	equalInt(t, 1, a.InFlight())
+
+	second.Release(now, Failure, time.Millisecond)
+	equalFloat(t, 1.5, a.Limit())
+	third, ok := a.Acquire(now)
+	expect(t, ok, "expected a token under the limit")
+	_, ok = a.Acquire(now)
+	expect(t, !ok, "expected fractional limits to round down")
+	third.Release(now, Ignore, time.Millisecond)
+	equalFloat(t, 1.5, a.Limit())
+
+	a.MinLimit = 1
+	a.Reset(now)
+	stale, _ := a.Acquire(now)
+	a.Reset(now)
+	stale.Release(now, Failure, 0)
+	equalInt(t, 0, a.InFlight())
+	equalFloat(t, 2, a.Limit())
+}
+
+func TestAIMDConcurrency_bounds(t *testing.T) {
+	now := time.Now()
+	a := &AIMDConcurrency{AdditiveIncrease: 100, MultiplicativeDecrease: .1, InitialLimit: 2, MinLimit: 2, MaxLimit: 10, LatencyThreshold: time.Second}
+	tok, _ := a.Acquire(now)
+	tok.Release(now, Success, time.Millisecond)
+	equalFloat(t, 10, a.Limit())
+	tok, _ = a.Acquire(now)
+	tok.Release(now, Success, time.Second*2)
+	equalFloat(t, 2, a.Limit())
+}
+
+// A backend that handles a fixed number of requests at once, failing any more, should see the limit settle around
+// that number.
+func TestAIMDConcurrency_converges(t *testing.T) {
+	const parallelism = 8
+	const latency = time.Millisecond * 10
+	a := &AIMDConcurrency{AdditiveIncrease: 1, MultiplicativeDecrease: .5, InitialLimit: 1, MaxLimit: 1000}
+	type request struct {
+		token  ConcurrencyToken
+		doneAt time.Time
+		ok     bool
+	}
+	var inFlight []request
+	now := time.Unix(1000, 0)
+	end := now.Add(time.Second * 20)
+	var sum float64
+	var samples int
+	for ; now.Before(end); now = now.Add(time.Millisecond) {
+		remaining := inFlight[:0]
+		for _, r := range inFlight {
+			if !now.Before(r.doneAt) {
+				if r.ok {
+					r.token.Release(now, Success, latency)
+				} else {
+					r.token.Release(now, Failure, latency)
+				}
+				continue
+			}
+			remaining = append(remaining, r)
+		}
+		inFlight = remaining
+		// Clients always have more requests to send
+		for {
+			tok, ok := a.Acquire(now)
+			if !ok {
+				break
+			}
+			inFlight = append(inFlight, request{token: tok, doneAt: now.Add(latency), ok: len(inFlight) < parallelism})
+		}
+		if now.After(end.Add(-time.Second * 10)) {
+			sum += a.Limit()
+			samples++
+		}
+	}
+	mean := sum / float64(samples)
+	t.Logf("mean limit %f", mean)
+	expect(t, mean > parallelism*.5 && mean < parallelism*1.5, "expected the limit to settle around the backend's parallelism")
+}
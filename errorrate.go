@@ -0,0 +1,106 @@
+package aimdcloser
+
+import (
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrorRateAIMD is an AIMD that decreases its rate on the recent error rate, instead of on every failure, so isolated
+// failures on a busy circuit do not cut its rate.  Successes and failures feed an exponentially weighted error rate
+// that forgets half of its history every HalfLife.  While that error rate is over Threshold, each failure multiplies
+// the rate by a factor between 1 and MultiplicativeDecrease, proportional to how far the error rate is over
+// Threshold.  The rate only increases while the error rate is at or under Threshold.
+// It is *NOT* thread safe
+type ErrorRateAIMD struct {
+	AIMD
+	// HalfLife is how long it takes for past events to count half as much
+	HalfLife time.Duration
+	// Threshold is the error rate (0.0, 1.0) over which failures decrease the rate
+	Threshold float64
+
+	// failures and events are decayed counts of recent events
+	failures   float64
+	events     float64
+	lastUpdate time.Time
+}
+
+// ErrorRateAIMDConstructor constructs rate limiters according to the given parameters.  See documentation for AIMD
+// and ErrorRateAIMD for what each parameter means.
+func ErrorRateAIMDConstructor(additiveIncrease float64, multiplicativeDecrease float64, initialRate float64, burst int, halfLife time.Duration, threshold float64) func() RateLimiter {
+	return func() RateLimiter {
+		return &ErrorRateAIMD{
+			AIMD: AIMD{
+				AdditiveIncrease:       additiveIncrease,
+				MultiplicativeDecrease: multiplicativeDecrease,
+				InitialRate:            initialRate,
+				Burst:                  burst,
+			},
+			HalfLife:  halfLife,
+			Threshold: threshold,
+		}
+	}
+}
+
+// observe decays the counts to now, then adds one event
+func (e *ErrorRateAIMD) observe(now time.Time, failed bool) {
+	if !e.lastUpdate.IsZero() && e.HalfLife > 0 {
+		if elapsed := now.Sub(e.lastUpdate); elapsed > 0 {
+			decay := math.Pow(.5, float64(elapsed)/float64(e.HalfLife))
+			e.failures *= decay
+			e.events *= decay
+		}
+	}
+	if now.After(e.lastUpdate) {
+		e.lastUpdate = now
+	}
+	e.events++
+	if failed {
+		e.failures++
+	}
+}
+
+// ErrorRate returns the current estimate of the error rate (0.0, 1.0)
+func (e *ErrorRateAIMD) ErrorRate() float64 {
+	if e.events == 0 {
+		return 0
+	}
+	return e.failures / e.events
+}
+
+// OnFailure counts a failure, then decreases the rate if the error rate is over Threshold
+func (e *ErrorRateAIMD) OnFailure(now time.Time) {
+	e.init(now)
+	e.observe(now, true)
+	errorRate := e.ErrorRate()
+	if errorRate <= e.Threshold {
+		return
+	}
+	excess := 1.0
+	if e.Threshold < 1 {
+		excess = math.Min(1, (errorRate-e.Threshold)/(1-e.Threshold))
+	}
+	factor := 1 - (1-e.MultiplicativeDecrease)*excess
+	e.l.SetLimitAt(now, rate.Limit(float64(e.l.Limit())*factor))
+}
+
+// OnSuccess counts a success, then increases the rate if the error rate is at or under Threshold
+func (e *ErrorRateAIMD) OnSuccess(now time.Time) {
+	e.init(now)
+	e.observe(now, false)
+	if e.ErrorRate() <= e.Threshold {
+		e.l.SetLimitAt(now, rate.Limit(float64(e.l.Limit())+e.AdditiveIncrease))
+	}
+}
+
+// Reset the rate back to InitialRate and forget the error rate
+func (e *ErrorRateAIMD) Reset(now time.Time) {
+	e.AIMD.Reset(now)
+	e.failures = 0
+	e.events = 0
+	e.lastUpdate = time.Time{}
+}
+
+var _ RateLimiter = &ErrorRateAIMD{}
+var _ RateReporter = &ErrorRateAIMD{}
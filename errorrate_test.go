@@ -0,0 +1,92 @@
+package aimdcloser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestErrorRateAIMDConstructor(t *testing.T) {
+	e := ErrorRateAIMDConstructor(1, .5, 10, 5, time.Second, .1)().(*ErrorRateAIMD)
+	equalFloat(t, 10, e.Rate())
+	equalFloat(t, .1, e.Threshold)
+	expect(t, e.HalfLife == time.Second, "expected the half life to be set")
+}
+
+func TestErrorRateAIMD_isolatedFailures(t *testing.T) {
+	e := &ErrorRateAIMD{
+		AIMD:      AIMD{AdditiveIncrease: 0, MultiplicativeDecrease: .5, InitialRate: 1000, Burst: 10},
+		HalfLife:  time.Second,
+		Threshold: .05,
+	}
+	now := time.Now()
+	// A busy circuit with one failure in a hundred
+	for i := 0; i < 1000; i++ {
+		now = now.Add(time.Millisecond)
+		if i%100 == 99 {
+			e.OnFailure(now)
+		} else {
+			e.OnSuccess(now)
+		}
+	}
+	equalFloat(t, 1000, e.Rate())
+	expect(t, e.ErrorRate() < .05, "expected a low error rate")
+
+	plain := &AIMD{MultiplicativeDecrease: .5, InitialRate: 1000, Burst: 10}
+	for i := 0; i < 10; i++ {
+		plain.OnFailure(now)
+	}
+	expect(t, plain.Rate() < 1, "expected plain AIMD to overreact to the same failures")
+}
+
+func TestErrorRateAIMD_proportional(t *testing.T) {
+	newLimiter := func() *ErrorRateAIMD {
+		return &ErrorRateAIMD{
+			AIMD:      AIMD{MultiplicativeDecrease: .5, InitialRate: 100, Burst: 10},
+			HalfLife:  time.Hour,
+			Threshold: .5,
+		}
+	}
+	now := time.Now()
+	e := newLimiter()
+	e.OnSuccess(now)
+	// An error rate of 1/2 is not over the threshold
+	e.OnFailure(now)
+	equalFloat(t, 100, e.Rate())
+	// An error rate of 2/3 is a third of the way from the threshold to 1, so the rate is multiplied by 5/6
+	e.OnFailure(now)
+	equalFloat(t, 100*5.0/6, e.Rate())
+	// An error rate of 3/4 is half way, so the rate is multiplied by 3/4
+	e.OnFailure(now)
+	equalFloat(t, .75, e.ErrorRate())
+	equalFloat(t, 100*5.0/6*.75, e.Rate())
+
+	// Every request failing is the full MultiplicativeDecrease
+	e = newLimiter()
+	e.OnFailure(now)
+	equalFloat(t, 50, e.Rate())
+}
+
+func TestErrorRateAIMD_decay(t *testing.T) {
+	e := &ErrorRateAIMD{
+		AIMD:      AIMD{AdditiveIncrease: 1, MultiplicativeDecrease: .5, InitialRate: 100, Burst: 10},
+		HalfLife:  time.Second,
+		Threshold: .2,
+	}
+	now := time.Now()
+	e.OnFailure(now)
+	equalFloat(t, 50, e.Rate())
+	// The failure counts 1/2, then a success counts 1, for an error rate of 1/3
+	now = now.Add(time.Second)
+	e.OnSuccess(now)
+	equalFloat(t, 1.0/3, e.ErrorRate())
+	equalFloat(t, 50, e.Rate())
+	// After a long time, the failure is forgotten
+	now = now.Add(time.Second * 30)
+	e.OnSuccess(now)
+	expect(t, e.ErrorRate() < .001, "expected old failures to be forgotten")
+	equalFloat(t, 51, e.Rate())
+
+	e.Reset(now)
+	equalFloat(t, 0, e.ErrorRate())
+	equalFloat(t, 100, e.Rate())
+}
@@ -1,6 +1,7 @@
 package aimdcloser_test
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -61,3 +62,43 @@ func ExampleAIMD_OnFailure() {
 	}
 	// Output: Request failed
 }
+
+func ExampleHierarchy() {
+	// One limiter for the whole host, shared by every endpoint
+	host := &aimdcloser.SyncRateLimiter{
+		RateLimiter: &aimdcloser.AIMD{
+			AdditiveIncrease:       1,
+			MultiplicativeDecrease: .5,
+			InitialRate:            100,
+			Burst:                  10,
+		},
+	}
+	// Each endpoint gets its own limiter, and only one in four of its failures slow down the host
+	endpoint := aimdcloser.HierarchyConstructor(host, aimdcloser.AIMDConstructor(1, .5, 10, 1), .25)
+	getUsers := endpoint()
+	if getUsers.AttemptReserve(time.Now()) {
+		fmt.Println("We make a request")
+	}
+	// Output: We make a request
+}
+
+func ExampleGuard_Do() {
+	g := aimdcloser.Guard{
+		RateLimiter: &aimdcloser.AIMD{
+			AdditiveIncrease:       1,
+			MultiplicativeDecrease: .5,
+			InitialRate:            100,
+			Burst:                  10,
+		},
+	}
+	err := g.Do(context.Background(), func(ctx context.Context) error {
+		// Call your backend here
+		return nil
+	})
+	if err == aimdcloser.ErrRateLimited {
+		fmt.Println("We skip making a request")
+	} else {
+		fmt.Println("We make a request")
+	}
+	// Output: We make a request
+}
@@ -0,0 +1,187 @@
+package aimdcloser
+
+import (
+	"math"
+	"time"
+)
+
+// GCRA is https://en.wikipedia.org/wiki/Generic_cell_rate_algorithm with its rate learned like AIMD.  Instead of a
+// bucket of tokens it keeps only its rate and a single theoretical arrival time (TAT): the time the next request
+// would be allowed if requests were evenly paced.  Requests are allowed up to Burst-1 emission intervals ahead of it.
+// That paces requests more smoothly than a token bucket, and keeps little state, which matters when there is a
+// limiter per key.
+//
+// GCRA is a DelayReserver, so Closer.AllowBy and Limiter can queue requests for it.  It is not a Reserver, since a
+// rate.Reservation only comes from a rate.Limiter.  Requests it reserves are never given back: Hierarchy keeps the
+// child's request when the parent denies it, Closer.Wait keeps a cancelled wait's request, ratesql keeps a skipped
+// query's request, and Limiter cannot reserve more than one request at a time.
+//
+// Emission intervals are at most a year, so a rate of zero allows Burst requests and then about one a year.
+// It is *NOT* thread safe
+type GCRA struct {
+	// How many requests / sec are added to the current rate when a success happens
+	AdditiveIncrease float64
+	// What the current rate is multiplied by on a failure
+	MultiplicativeDecrease float64
+	// The rate of requests / sec to start at when reset
+	InitialRate float64
+	// Burst is how many requests may be made at once
+	Burst int
+
+	// tat is the theoretical arrival time, in unix nanoseconds.  A pause pushes it past the pause's end.  Zero means
+	// the limiter has not been used, and gcraLongAgo allows a full burst.
+	tat  int64
+	rate float64
+}
+
+const (
+	maxGCRAInterval  = int64(time.Hour * 24 * 365)
+	maxGCRATolerance = math.MaxInt64 / 4
+	// gcraLongAgo is a tat so far in the past that a full burst is allowed, without being zero
+	gcraLongAgo = int64(1)
+)
+
+// GCRAConstructor constructs rate limiters according to the given parameters.  See documentation for GCRA for what
+// each parameter means.
+func GCRAConstructor(additiveIncrease float64, multiplicativeDecrease float64, initialRate float64, burst int) func() RateLimiter {
+	return func() RateLimiter {
+		return &GCRA{
+			AdditiveIncrease:       additiveIncrease,
+			MultiplicativeDecrease: multiplicativeDecrease,
+			InitialRate:            initialRate,
+			Burst:                  burst,
+		}
+	}
+}
+
+// Reset the RateLimiter back to the initial rate, with a full burst.  If requests are denied right now, for example
+// by a pause, they stay denied until the same time and are then paced at the initial rate.
+func (g *GCRA) Reset(now time.Time) {
+	next := int64(0)
+	if g.tat != 0 {
+		next = g.tat - g.tolerance(g.interval())
+	}
+	g.rate = g.InitialRate
+	if next > now.UnixNano() {
+		g.tat = next + g.tolerance(g.interval())
+	} else {
+		g.tat = gcraLongAgo
+	}
+}
+
+func (g *GCRA) init(now time.Time) {
+	if g.tat == 0 {
+		g.Reset(now)
+	}
+}
+
+// interval is the emission interval of the current rate, in nanoseconds
+func (g *GCRA) interval() int64 {
+	if g.rate <= 0 {
+		return maxGCRAInterval
+	}
+	i := float64(time.Second) / g.rate
+	if i >= float64(maxGCRAInterval) {
+		return maxGCRAInterval
+	}
+	return int64(math.Round(i))
+}
+
+// tolerance is how far ahead of tat a request may be allowed
+func (g *GCRA) tolerance(interval int64) int64 {
+	n := int64(g.Burst - 1)
+	if n <= 0 {
+		return 0
+	}
+	if interval > maxGCRATolerance/n {
+		return maxGCRATolerance
+	}
+	return interval * n
+}
+
+// setRate changes the rate, keeping how many requests of the burst are used
+func (g *GCRA) setRate(now time.Time, rate float64) {
+	previous := g.interval()
+	g.rate = rate
+	n := now.UnixNano()
+	if g.tat > n && previous > 0 {
+		g.tat = n + int64(float64(g.tat-n)*float64(g.interval())/float64(previous))
+	}
+}
+
+// reserve reserves a request allowed within maxWait, and returns how long until it is allowed
+func (g *GCRA) reserve(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	g.init(now)
+	if g.Burst <= 0 {
+		return 0, false
+	}
+	n := now.UnixNano()
+	interval := g.interval()
+	wait := time.Duration(g.tat - g.tolerance(interval) - n)
+	if wait < 0 {
+		wait = 0
+	}
+	if wait > maxWait {
+		return 0, false
+	}
+	if g.tat < n {
+		g.tat = n
+	}
+	g.tat += interval
+	return wait, true
+}
+
+// AttemptReserve tries to reserve a request at now.  Returns if the rate limiter allows you to reserve a request.
+func (g *GCRA) AttemptReserve(now time.Time) bool {
+	_, ok := g.reserve(now, 0)
+	return ok
+}
+
+// ReserveWithin reserves a request that the current rate allows within maxWait
+func (g *GCRA) ReserveWithin(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	return g.reserve(now, maxWait)
+}
+
+// OnSuccess increases the rate by AdditiveIncrease
+func (g *GCRA) OnSuccess(now time.Time) {
+	g.init(now)
+	g.setRate(now, g.rate+g.AdditiveIncrease)
+}
+
+// OnFailure multiplies the rate by MultiplicativeDecrease
+func (g *GCRA) OnFailure(now time.Time) {
+	g.init(now)
+	g.setRate(now, g.rate*g.MultiplicativeDecrease)
+}
+
+// OnHint jumps to the hinted rate, and denies every request until the hinted pause is over.  Requests after a pause
+// are paced at the rate, without a burst.
+func (g *GCRA) OnHint(now time.Time, hint Hint) {
+	g.init(now)
+	if hint.Rate > 0 {
+		g.setRate(now, hint.Rate)
+	}
+	if !hint.PauseUntil.IsZero() {
+		if tat := hint.PauseUntil.UnixNano() + g.tolerance(g.interval()); tat > g.tat {
+			g.tat = tat
+		}
+	}
+}
+
+// Rate returns the current rate
+func (g *GCRA) Rate() float64 {
+	if g.tat == 0 {
+		return g.InitialRate
+	}
+	return g.rate
+}
+
+// MaxBurst returns Burst
+func (g *GCRA) MaxBurst() int {
+	return g.Burst
+}
+
+var _ RateLimiter = &GCRA{}
+var _ RateReporter = &GCRA{}
+var _ RateHinter = &GCRA{}
+var _ DelayReserver = &GCRA{}
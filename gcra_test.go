@@ -0,0 +1,96 @@
+package aimdcloser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGCRANormalRate(t *testing.T) {
+	testNormalRate(t, GCRAConstructor)
+}
+
+func TestGCRAFailures(t *testing.T) {
+	testFailures(t, GCRAConstructor)
+}
+
+func TestGCRABurst(t *testing.T) {
+	testBurst(t, GCRAConstructor)
+}
+
+func TestGCRAConstructor(t *testing.T) {
+	r := GCRAConstructor(10, .1, 10, 10)().(*GCRA)
+	equalFloat(t, 10, r.AdditiveIncrease)
+	equalFloat(t, .1, r.MultiplicativeDecrease)
+	equalFloat(t, 10, r.InitialRate)
+	equalInt(t, 10, r.Burst)
+	equalFloat(t, 10, r.Rate())
+	equalInt(t, 10, r.MaxBurst())
+}
+
+func TestGCRA_rateChangeKeepsBurstUsed(t *testing.T) {
+	g := &GCRA{AdditiveIncrease: 10, MultiplicativeDecrease: .5, InitialRate: 10, Burst: 2}
+	now := time.Now()
+	expect(t, g.AttemptReserve(now), "expected the burst to be free")
+	expect(t, g.AttemptReserve(now), "expected the burst to be free")
+	expect(t, !g.AttemptReserve(now), "expected the burst to be used")
+	// Doubling the rate halves the wait for the next request, from 100ms to 50ms
+	g.OnSuccess(now)
+	equalFloat(t, 20, g.Rate())
+	expect(t, !g.AttemptReserve(now.Add(time.Millisecond*49)), "expected to wait for the faster rate")
+	expect(t, g.AttemptReserve(now.Add(time.Millisecond*50)), "expected a request at the faster rate")
+}
+
+func TestGCRA_zeroRate(t *testing.T) {
+	g := &GCRA{InitialRate: 0, Burst: 2}
+	now := time.Now()
+	expect(t, g.AttemptReserve(now), "expected the burst to be free")
+	expect(t, g.AttemptReserve(now), "expected the burst to be free")
+	expect(t, !g.AttemptReserve(now.Add(time.Hour)), "expected nothing past the burst")
+	expect(t, !(&GCRA{InitialRate: 1}).AttemptReserve(now), "expected no requests without a burst")
+}
+
+func TestGCRA_ReserveWithin(t *testing.T) {
+	g := &GCRA{InitialRate: 10, Burst: 1}
+	now := time.Now()
+	wait, ok := g.ReserveWithin(now, 0)
+	expect(t, ok && wait == 0, "expected the burst to be free")
+	wait, ok = g.ReserveWithin(now, time.Second)
+	expect(t, ok && wait == time.Millisecond*100, "expected to wait for the next interval")
+	_, ok = g.ReserveWithin(now, time.Millisecond*150)
+	expect(t, !ok, "expected the third request to be too far away")
+	wait, ok = g.ReserveWithin(now, time.Millisecond*200)
+	expect(t, ok && wait == time.Millisecond*200, "expected a refused reservation not to be kept")
+}
+
+func TestGCRA_OnHint(t *testing.T) {
+	g := &GCRA{AdditiveIncrease: 1, MultiplicativeDecrease: .5, InitialRate: 100, Burst: 2}
+	now := time.Now()
+	g.OnHint(now, Hint{Rate: 5})
+	equalFloat(t, 5, g.Rate())
+	g.OnHint(now, Hint{PauseUntil: now.Add(time.Second)})
+	expect(t, !g.AttemptReserve(now), "expected no requests during a pause")
+	expect(t, !g.AttemptReserve(now.Add(time.Second-time.Nanosecond)), "expected no requests during a pause")
+	now = now.Add(time.Second)
+	expect(t, g.AttemptReserve(now), "expected requests once the pause is over")
+	expect(t, !g.AttemptReserve(now), "expected requests to be paced once the pause is over")
+	now = now.Add(time.Second / 5)
+	g.Reset(now)
+	expect(t, g.AttemptReserve(now) && g.AttemptReserve(now), "expected reset to forget an ended pause")
+	equalFloat(t, 100, g.Rate())
+
+	g.OnHint(now, Hint{PauseUntil: now.Add(time.Hour)})
+	g.Reset(now)
+	expect(t, !g.AttemptReserve(now), "expected reset to keep a pause")
+	expect(t, g.AttemptReserve(now.Add(time.Hour)), "expected requests once the pause is over")
+	expect(t, !g.AttemptReserve(now.Add(time.Hour)), "expected requests to be paced at the initial rate")
+	expect(t, g.AttemptReserve(now.Add(time.Hour+time.Second/100)), "expected requests to be paced at the initial rate")
+}
+
+func BenchmarkGCRA_AttemptReserve(b *testing.B) {
+	g := &GCRA{InitialRate: 1e9, Burst: 10}
+	now := time.Now()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		g.AttemptReserve(now.Add(time.Duration(i)))
+	}
+}
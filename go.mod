@@ -5,4 +5,5 @@ go 1.10
 require (
 	github.com/cep21/circuit/v3 v3.0.0
 	golang.org/x/time v0.0.0-20190308202827-9d24e82272b4
+	google.golang.org/grpc v1.18.0
 )
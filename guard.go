@@ -0,0 +1,131 @@
+package aimdcloser
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned by Guard.Do when the rate limiter does not allow the call.  The function is not run.
+var ErrRateLimited = errors.New("aimdcloser: rate limited")
+
+// RateLimitedError is ErrRateLimited for callers that keep one rate limiter per key, such as a host.  The call is
+// not made.
+type RateLimitedError struct {
+	// Key is the key whose rate limiter did not allow the call
+	Key string
+}
+
+func (e *RateLimitedError) Error() string {
+	return "aimdcloser: " + e.Key + " rate limited"
+}
+
+// Outcome is what a call tells a rate limiter
+type Outcome int
+
+const (
+	// Ignore does not change the rate
+	Ignore Outcome = iota
+	// Success increases the rate
+	Success
+	// Failure decreases the rate
+	Failure
+)
+
+// ClassifyError is the default classification of Guard.  A nil error is a success.  Canceled contexts, and
+// ErrRateLimited or a *RateLimitedError from limiters nested inside the function, are ignored since they say nothing
+// about the backend.  Every other error is a failure.
+func ClassifyError(err error) Outcome {
+	switch err {
+	case nil:
+		return Success
+	case context.Canceled, ErrRateLimited:
+		return Ignore
+	}
+	if _, ok := err.(*RateLimitedError); ok {
+		return Ignore
+	}
+	return Failure
+}
+
+// Guard runs functions through a RateLimiter: it reserves, runs the function, and reports how it went.  It is safe
+// for concurrent use.  RateLimiter does not need to be, as long as only the Guard uses it.
+type Guard struct {
+	// RateLimiter decides if calls are allowed
+	RateLimiter RateLimiter
+	// Classify decides what the error of a call tells RateLimiter.  Defaults to ClassifyError.
+	Classify func(err error) Outcome
+	// Now should simulate time.Now.  Defaults to time.Now.
+	Now func() time.Time
+
+	mu sync.Mutex
+}
+
+func (g *Guard) now() time.Time {
+	if g.Now == nil {
+		return time.Now()
+	}
+	return g.Now()
+}
+
+func (g *Guard) classify(err error) Outcome {
+	if g.Classify == nil {
+		return ClassifyError(err)
+	}
+	return g.Classify(err)
+}
+
+// Do runs f if RateLimiter allows it, and returns ErrRateLimited if not.  The error of f is returned as is after
+// it is reported to RateLimiter.  f is not run if ctx is already done.  If RateLimiter is a PriorityReserver, the
+// priority of ctx is used.  See WithPriority.
+func (g *Guard) Do(ctx context.Context, f func(ctx context.Context) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	allowed := g.attemptReserve(ctx)
+	g.mu.Unlock()
+	if !allowed {
+		return ErrRateLimited
+	}
+	err := f(ctx)
+	switch g.classify(err) {
+	case Success:
+		g.mu.Lock()
+		g.RateLimiter.OnSuccess(g.now())
+		g.mu.Unlock()
+	case Failure:
+		g.mu.Lock()
+		g.RateLimiter.OnFailure(g.now())
+		g.mu.Unlock()
+	}
+	return err
+}
+
+func (g *Guard) attemptReserve(ctx context.Context) bool {
+	if pr, ok := g.RateLimiter.(PriorityReserver); ok {
+		if priority, ok := PriorityFromContext(ctx); ok {
+			return pr.AttemptReservePriority(g.now(), priority)
+		}
+	}
+	return g.RateLimiter.AttemptReserve(g.now())
+}
+
+// Hint gives a hint from the backend to RateLimiter, if it is a RateHinter
+func (g *Guard) Hint(hint Hint) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ApplyHint(g.RateLimiter, g.now(), hint)
+}
+
+// Rate returns the current rate of RateLimiter, or NaN if it is not a RateReporter
+func (g *Guard) Rate() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if r, ok := g.RateLimiter.(RateReporter); ok {
+		return r.Rate()
+	}
+	return math.NaN()
+}
@@ -0,0 +1,140 @@
+package aimdcloser
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	expect(t, ClassifyError(nil) == Success, "expected nil to be a success")
+	expect(t, ClassifyError(errors.New("bad")) == Failure, "expected errors to be failures")
+	expect(t, ClassifyError(context.Canceled) == Ignore, "expected cancels to be ignored")
+	expect(t, ClassifyError(ErrRateLimited) == Ignore, "expected nested rate limits to be ignored")
+	expect(t, ClassifyError(&RateLimitedError{Key: "host"}) == Ignore, "expected nested keyed rate limits to be ignored")
+}
+
+func TestGuard_Do(t *testing.T) {
+	now := time.Now()
+	g := &Guard{
+		RateLimiter: &AIMD{AdditiveIncrease: 1, MultiplicativeDecrease: .5, InitialRate: 10, Burst: 3},
+		Now: func() time.Time {
+			return now
+		},
+	}
+	calls := 0
+	expectNilErr(t, g.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return nil
+	}))
+	equalFloat(t, 11, g.Rate())
+	bad := errors.New("bad")
+	err := g.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return bad
+	})
+	expect(t, err == bad, "expected the error of the function")
+	equalFloat(t, 5.5, g.Rate())
+	expectNilErr(t, g.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return nil
+	}))
+	err = g.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return nil
+	})
+	expect(t, err == ErrRateLimited, "expected the burst to be used up")
+	equalInt(t, 3, calls)
+}
+
+func TestGuard_doneContext(t *testing.T) {
+	g := &Guard{
+		RateLimiter: &AIMD{InitialRate: 10, Burst: 1},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := g.Do(ctx, func(ctx context.Context) error {
+		t.Fatal("expected the function not to run")
+		return nil
+	})
+	expect(t, err == context.Canceled, "expected the context's error")
+	expectNilErr(t, g.Do(context.Background(), func(ctx context.Context) error {
+		return nil
+	}))
+}
+
+func TestGuard_Classify(t *testing.T) {
+	notFound := errors.New("not found")
+	g := &Guard{
+		RateLimiter: &AIMD{AdditiveIncrease: 1, MultiplicativeDecrease: .5, InitialRate: 10, Burst: 10},
+		Classify: func(err error) Outcome {
+			if err == notFound {
+				return Success
+			}
+			return ClassifyError(err)
+		},
+	}
+	err := g.Do(context.Background(), func(ctx context.Context) error {
+		return notFound
+	})
+	expect(t, err == notFound, "expected the error of the function")
+	equalFloat(t, 11, g.Rate())
+}
+
+func TestGuard_concurrent(t *testing.T) {
+	g := &Guard{
+		RateLimiter: &AIMD{AdditiveIncrease: 1, MultiplicativeDecrease: .5, InitialRate: 1000, Burst: 1000},
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				_ = g.Do(context.Background(), func(ctx context.Context) error {
+					if (i+j)%2 == 0 {
+						return errors.New("bad")
+					}
+					return nil
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+	expect(t, g.Rate() < 1000, "expected failures to lower the rate")
+}
+
+func TestGuard_notReporter(t *testing.T) {
+	g := &Guard{
+		RateLimiter: &Hierarchy{Child: &AIMD{}, Parent: &SyncRateLimiter{RateLimiter: &AIMD{}}},
+	}
+	expect(t, !math.IsNaN(g.Rate()), "expected a hierarchy of AIMD to report its rate")
+	g.RateLimiter = neverLimits{}
+	expect(t, math.IsNaN(g.Rate()), "expected NaN from limiters that cannot report a rate")
+}
+
+type neverLimits struct{}
+
+func (neverLimits) OnFailure(now time.Time)           {}
+func (neverLimits) OnSuccess(now time.Time)           {}
+func (neverLimits) AttemptReserve(now time.Time) bool { return true }
+func (neverLimits) Reset(now time.Time)               {}
+
+func TestGuard_Hint(t *testing.T) {
+	now := time.Now()
+	g := &Guard{
+		RateLimiter: &AIMD{InitialRate: 10, Burst: 1},
+		Now: func() time.Time {
+			return now
+		},
+	}
+	g.Hint(Hint{Rate: 3, PauseUntil: now.Add(time.Second)})
+	equalFloat(t, 3, g.Rate())
+	err := g.Do(context.Background(), func(ctx context.Context) error {
+		return nil
+	})
+	expect(t, err == ErrRateLimited, "expected the pause to rate limit")
+}
@@ -0,0 +1,226 @@
+package aimdcloser
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Hierarchy is a RateLimiter that allows a request only if both Child and Parent allow it.  A common setup is an AIMD
+// per endpoint as Child, with one AIMD per host as a Parent shared by every endpoint of that host.  Failures of
+// Child reach Parent only partially, so a misbehaving endpoint mostly throttles itself, while failures across many
+// endpoints add up and throttle the whole host.  Attenuated failures only add up across hierarchies made by the same
+// HierarchyConstructor, which shares the count along with Parent.
+// It is *NOT* thread safe, but Parent must be, since it is shared.  See SyncRateLimiter.
+type Hierarchy struct {
+	// Child is the limiter of only this hierarchy
+	Child RateLimiter
+	// Parent is usually shared between many hierarchies.  It must be safe for concurrent use.
+	Parent RateLimiter
+	// What fraction (0.0, 1.0) of failures are sent to Parent.  Zero never sends Parent a failure, while 1 sends
+	// Parent every failure.  Successes are always sent.
+	FailureAttenuation float64
+
+	// failures accumulates attenuated failures until one is sent to Parent.  It is shared by every hierarchy of a
+	// HierarchyConstructor, and created on first use otherwise.
+	failures *attenuatedFailures
+}
+
+// attenuatedFailures accumulates attenuated failures of every child of a parent
+type attenuatedFailures struct {
+	pending float64
+	mu      sync.Mutex
+}
+
+// add adds a failure of weight, and returns how many whole failures to send the parent
+func (a *attenuatedFailures) add(weight float64) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pending += weight
+	ret := 0
+	for a.pending >= 1 {
+		a.pending--
+		ret++
+	}
+	return ret
+}
+
+// HierarchyConstructor constructs hierarchies that all share parent.  See documentation for Hierarchy for what
+// each parameter means.
+func HierarchyConstructor(parent RateLimiter, child func() RateLimiter, failureAttenuation float64) func() RateLimiter {
+	failures := &attenuatedFailures{}
+	return func() RateLimiter {
+		return &Hierarchy{
+			Child:              child(),
+			Parent:             parent,
+			FailureAttenuation: failureAttenuation,
+			failures:           failures,
+		}
+	}
+}
+
+// OnFailure lowers the rate of Child, and sends Parent a failure once enough attenuated failures of every child add
+// up.
+func (h *Hierarchy) OnFailure(now time.Time) {
+	h.Child.OnFailure(now)
+	if h.failures == nil {
+		h.failures = &attenuatedFailures{}
+	}
+	for i := h.failures.add(h.FailureAttenuation); i > 0; i-- {
+		h.Parent.OnFailure(now)
+	}
+}
+
+// OnSuccess increases the rate of both Child and Parent.
+func (h *Hierarchy) OnSuccess(now time.Time) {
+	h.Child.OnSuccess(now)
+	h.Parent.OnSuccess(now)
+}
+
+// AttemptReserve reserves from Child, then Parent.  Parent is only asked if Child allows the request.  If Parent
+// does not allow it, the reservation taken from Child is cancelled when Child is a Reserver.  Other children have
+// no way to give a reservation back, so it is kept.
+func (h *Hierarchy) AttemptReserve(now time.Time) bool {
+	r, ok := h.Child.(Reserver)
+	if !ok {
+		return h.Child.AttemptReserve(now) && h.Parent.AttemptReserve(now)
+	}
+	res := r.ReserveN(now, 1)
+	if !res.OK() {
+		return false
+	}
+	if res.DelayFrom(now) > 0 || !h.Parent.AttemptReserve(now) {
+		res.CancelAt(now)
+		return false
+	}
+	return true
+}
+
+// Reset resets only Child.  Parent, and the failures pending for it, are shared with other hierarchies, so
+// resetting them is left to their owner.
+func (h *Hierarchy) Reset(now time.Time) {
+	h.Child.Reset(now)
+}
+
+// Rate returns the lower rate of Child and Parent, or NaN if either cannot report its rate.
+func (h *Hierarchy) Rate() float64 {
+	c, ok1 := h.Child.(RateReporter)
+	p, ok2 := h.Parent.(RateReporter)
+	if !ok1 || !ok2 {
+		return math.NaN()
+	}
+	return math.Min(c.Rate(), p.Rate())
+}
+
+// MaxBurst returns the lower burst of Child and Parent, or zero if either cannot report its burst.
+func (h *Hierarchy) MaxBurst() int {
+	c, ok1 := h.Child.(RateReporter)
+	p, ok2 := h.Parent.(RateReporter)
+	if !ok1 || !ok2 {
+		return 0
+	}
+	if c.MaxBurst() < p.MaxBurst() {
+		return c.MaxBurst()
+	}
+	return p.MaxBurst()
+}
+
+// OnHint gives the hint to Child, if it is a RateHinter.  Hints usually come from one endpoint, so Parent is left
+// alone.
+func (h *Hierarchy) OnHint(now time.Time, hint Hint) {
+	ApplyHint(h.Child, now, hint)
+}
+
+var _ RateLimiter = &Hierarchy{}
+var _ RateReporter = &Hierarchy{}
+var _ RateHinter = &Hierarchy{}
+
+// SyncRateLimiter makes a RateLimiter safe for concurrent use by protecting it with a mutex.
+type SyncRateLimiter struct {
+	RateLimiter RateLimiter
+	mu          sync.Mutex
+}
+
+// OnFailure calls OnFailure of RateLimiter
+func (s *SyncRateLimiter) OnFailure(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.RateLimiter.OnFailure(now)
+}
+
+// OnSuccess calls OnSuccess of RateLimiter
+func (s *SyncRateLimiter) OnSuccess(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.RateLimiter.OnSuccess(now)
+}
+
+// AttemptReserve calls AttemptReserve of RateLimiter
+func (s *SyncRateLimiter) AttemptReserve(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.RateLimiter.AttemptReserve(now)
+}
+
+// Reset calls Reset of RateLimiter
+func (s *SyncRateLimiter) Reset(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.RateLimiter.Reset(now)
+}
+
+// Rate returns the rate of RateLimiter, or NaN if it is not a RateReporter
+func (s *SyncRateLimiter) Rate() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.RateLimiter.(RateReporter); ok {
+		return r.Rate()
+	}
+	return math.NaN()
+}
+
+// MaxBurst returns the burst of RateLimiter, or zero if it is not a RateReporter
+func (s *SyncRateLimiter) MaxBurst() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.RateLimiter.(RateReporter); ok {
+		return r.MaxBurst()
+	}
+	return 0
+}
+
+// OnHint gives the hint to RateLimiter, if it is a RateHinter
+func (s *SyncRateLimiter) OnHint(now time.Time, hint Hint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ApplyHint(s.RateLimiter, now, hint)
+}
+
+// ReserveWithin calls ReserveWithin of RateLimiter if it is a DelayReserver, or only reserves a request for now
+// if not.
+func (s *SyncRateLimiter) ReserveWithin(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.RateLimiter.(DelayReserver); ok {
+		return d.ReserveWithin(now, maxWait)
+	}
+	return 0, s.RateLimiter.AttemptReserve(now)
+}
+
+// ReserveN calls ReserveN of RateLimiter if it is a Reserver.  The reservation is not OK if it is not.
+func (s *SyncRateLimiter) ReserveN(now time.Time, n int) *rate.Reservation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.RateLimiter.(Reserver); ok {
+		return r.ReserveN(now, n)
+	}
+	return notOK(now)
+}
+
+var _ RateLimiter = &SyncRateLimiter{}
+var _ RateReporter = &SyncRateLimiter{}
+var _ RateHinter = &SyncRateLimiter{}
+var _ DelayReserver = &SyncRateLimiter{}
+var _ Reserver = &SyncRateLimiter{}
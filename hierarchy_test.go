@@ -0,0 +1,145 @@
+package aimdcloser
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHierarchyConstructor(t *testing.T) {
+	parent := &SyncRateLimiter{RateLimiter: &AIMD{InitialRate: 10, Burst: 5}}
+	c := HierarchyConstructor(parent, AIMDConstructor(1, .5, 2, 1), .5)
+	h := c().(*Hierarchy)
+	expect(t, h.Parent == parent, "expected the parent to be shared")
+	expect(t, c().(*Hierarchy).Child != h.Child, "expected a new child each time")
+	equalFloat(t, .5, h.FailureAttenuation)
+	equalFloat(t, 2, h.Rate())
+	equalInt(t, 1, h.MaxBurst())
+}
+
+func TestHierarchy_AttemptReserve(t *testing.T) {
+	parent := &SyncRateLimiter{RateLimiter: &AIMD{InitialRate: 1, Burst: 2}}
+	c := HierarchyConstructor(parent, AIMDConstructor(1, .5, 1, 1), 0)
+	a := c()
+	b := c()
+	cc := c()
+	now := time.Now()
+	expect(t, a.AttemptReserve(now), "expected child and parent to allow")
+	expect(t, !a.AttemptReserve(now), "expected the child to deny its second request")
+	expect(t, b.AttemptReserve(now), "expected a sibling to use the rest of the parent")
+	expect(t, !cc.AttemptReserve(now), "expected the parent to deny once its burst is used")
+}
+
+func TestHierarchy_misbehavingChild(t *testing.T) {
+	parent := &SyncRateLimiter{RateLimiter: &AIMD{InitialRate: 100, Burst: 10, MultiplicativeDecrease: .5}}
+	c := HierarchyConstructor(parent, AIMDConstructor(1, .5, 10, 1), .2)
+	bad := c().(*Hierarchy)
+	good := c().(*Hierarchy)
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		bad.OnFailure(now)
+	}
+	equalFloat(t, 10*.5*.5*.5*.5, bad.Child.(*AIMD).Rate())
+	equalFloat(t, 10, good.Rate())
+	equalFloat(t, 100, parent.Rate())
+	bad.OnFailure(now)
+	equalFloat(t, 50, parent.Rate())
+}
+
+func TestHierarchy_hostOverload(t *testing.T) {
+	parent := &SyncRateLimiter{RateLimiter: &AIMD{InitialRate: 100, Burst: 10, MultiplicativeDecrease: .5}}
+	c := HierarchyConstructor(parent, AIMDConstructor(1, .5, 50, 1), .5)
+	children := []RateLimiter{c(), c(), c(), c()}
+	now := time.Now()
+	for _, child := range children {
+		child.OnFailure(now)
+		child.OnFailure(now)
+	}
+	equalFloat(t, 100*.5*.5*.5*.5, parent.Rate())
+	for _, child := range children {
+		equalFloat(t, 100*.5*.5*.5*.5, child.(*Hierarchy).Rate())
+	}
+}
+
+func TestHierarchy_failuresAcrossChildren(t *testing.T) {
+	parent := &SyncRateLimiter{RateLimiter: &AIMD{InitialRate: 100, Burst: 10, MultiplicativeDecrease: .99}}
+	c := HierarchyConstructor(parent, AIMDConstructor(1, .5, 50, 1), .5)
+	now := time.Now()
+	for i := 0; i < 50; i++ {
+		c().OnFailure(now)
+	}
+	equalFloat(t, 100*math.Pow(.99, 25), parent.Rate())
+}
+
+func TestHierarchy_AttemptReserveReturnsChildToken(t *testing.T) {
+	parent := &SyncRateLimiter{RateLimiter: &AIMD{InitialRate: 1, Burst: 1}}
+	h := HierarchyConstructor(parent, AIMDConstructor(1, .5, 1, 1), 0)()
+	sibling := HierarchyConstructor(parent, AIMDConstructor(1, .5, 1, 1), 0)()
+	now := time.Now()
+	expect(t, sibling.AttemptReserve(now), "expected the sibling to use the parent's burst")
+	expect(t, !h.AttemptReserve(now), "expected the parent to deny")
+	// The parent refills first, and the child still has its token
+	parent.Reset(now)
+	expect(t, h.AttemptReserve(now), "expected the child's token to be returned when the parent denied")
+}
+
+func TestHierarchy_OnSuccess(t *testing.T) {
+	parent := &SyncRateLimiter{RateLimiter: &AIMD{InitialRate: 10, Burst: 10, AdditiveIncrease: 1}}
+	h := HierarchyConstructor(parent, AIMDConstructor(2, .5, 10, 1), 1)()
+	h.OnSuccess(time.Now())
+	equalFloat(t, 11, parent.Rate())
+	equalFloat(t, 12, h.(*Hierarchy).Child.(*AIMD).Rate())
+}
+
+func TestHierarchy_Reset(t *testing.T) {
+	parent := &SyncRateLimiter{RateLimiter: &AIMD{InitialRate: 10, Burst: 10, MultiplicativeDecrease: .5}}
+	h := HierarchyConstructor(parent, AIMDConstructor(1, .5, 10, 1), 1)()
+	now := time.Now()
+	h.OnFailure(now)
+	h.Reset(now)
+	equalFloat(t, 10, h.(*Hierarchy).Child.(*AIMD).Rate())
+	equalFloat(t, 5, parent.Rate())
+}
+
+func TestHierarchy_notReporter(t *testing.T) {
+	h := &Hierarchy{
+		Child:  &AIMD{},
+		Parent: &SyncRateLimiter{RateLimiter: &Hierarchy{}},
+	}
+	expect(t, math.IsNaN(h.Rate()), "expected NaN rate")
+	equalInt(t, 0, h.MaxBurst())
+}
+
+func TestSyncRateLimiter(t *testing.T) {
+	s := &SyncRateLimiter{RateLimiter: &AIMD{InitialRate: 1000, Burst: 10, AdditiveIncrease: 1, MultiplicativeDecrease: .99}}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			now := time.Now()
+			for j := 0; j < 100; j++ {
+				s.AttemptReserve(now)
+				s.OnSuccess(now)
+				s.OnFailure(now)
+				s.Rate()
+			}
+		}()
+	}
+	wg.Wait()
+	s.Reset(time.Now())
+	equalFloat(t, 1000, s.Rate())
+	equalInt(t, 10, s.MaxBurst())
+	expect(t, math.IsNaN((&SyncRateLimiter{RateLimiter: &Hierarchy{}}).Rate()), "expected NaN rate")
+}
+
+func TestHierarchy_OnHint(t *testing.T) {
+	parent := &SyncRateLimiter{RateLimiter: &AIMD{InitialRate: 100, Burst: 10}}
+	h := HierarchyConstructor(parent, AIMDConstructor(1, .5, 10, 1), 0)().(*Hierarchy)
+	h.OnHint(time.Now(), Hint{Rate: 2})
+	equalFloat(t, 2, h.Child.(RateReporter).Rate())
+	equalFloat(t, 100, parent.Rate())
+	parent.OnHint(time.Now(), Hint{Rate: 50})
+	equalFloat(t, 50, parent.Rate())
+}
@@ -0,0 +1,117 @@
+package aimdcloser
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// JitteredAIMD is an AIMD whose steps are randomly varied, so many clients of one backend do not ramp up and back
+// off in lockstep.  Each jitter is the largest fraction (0.0, 1.0) a step is varied by.  A jitter of zero leaves
+// that step as AIMD would take it.
+// It is *NOT* thread safe
+type JitteredAIMD struct {
+	AIMD
+	// IncreaseJitter varies AdditiveIncrease up or down by up to this fraction on each success
+	IncreaseJitter float64
+	// DecreaseJitter varies MultiplicativeDecrease up or down by up to this fraction on each failure
+	DecreaseJitter float64
+	// ResetJitter starts each reset up to this fraction below InitialRate, so clients that reset together do not
+	// all start at InitialRate
+	ResetJitter float64
+	// Rand returns random numbers in [0.0, 1.0).  Defaults to rand.Float64.  Inject a seeded source for
+	// repeatable tests.
+	Rand func() float64
+}
+
+// JitteredAIMDConstructor constructs rate limiters according to the given parameters, with every jitter set to
+// jitter.  See documentation for AIMD and JitteredAIMD for what each parameter means.
+func JitteredAIMDConstructor(additiveIncrease float64, multiplicativeDecrease float64, initialRate float64, burst int, jitter float64) func() RateLimiter {
+	return func() RateLimiter {
+		return &JitteredAIMD{
+			AIMD: AIMD{
+				AdditiveIncrease:       additiveIncrease,
+				MultiplicativeDecrease: multiplicativeDecrease,
+				InitialRate:            initialRate,
+				Burst:                  burst,
+			},
+			IncreaseJitter: jitter,
+			DecreaseJitter: jitter,
+			ResetJitter:    jitter,
+		}
+	}
+}
+
+func (j *JitteredAIMD) random() float64 {
+	if j.Rand == nil {
+		return rand.Float64()
+	}
+	return j.Rand()
+}
+
+// vary returns x changed up or down by up to the fraction jitter
+func (j *JitteredAIMD) vary(x float64, jitter float64) float64 {
+	if jitter == 0 {
+		return x
+	}
+	return x * (1 + jitter*(2*j.random()-1))
+}
+
+func (j *JitteredAIMD) init(now time.Time) {
+	if j.l == nil {
+		j.Reset(now)
+	}
+}
+
+// Reset the rate to InitialRate, less up to ResetJitter of it
+func (j *JitteredAIMD) Reset(now time.Time) {
+	j.AIMD.Reset(now)
+	if j.ResetJitter != 0 && j.InitialRate != 0 {
+		j.l.SetLimitAt(now, rate.Limit(j.InitialRate*(1-j.ResetJitter*j.random())))
+	}
+}
+
+// OnSuccess increases the rate by AdditiveIncrease, varied by IncreaseJitter
+func (j *JitteredAIMD) OnSuccess(now time.Time) {
+	j.init(now)
+	j.l.SetLimitAt(now, rate.Limit(float64(j.l.Limit())+j.vary(j.AdditiveIncrease, j.IncreaseJitter)))
+}
+
+// OnFailure multiplies the rate by MultiplicativeDecrease, varied by DecreaseJitter
+func (j *JitteredAIMD) OnFailure(now time.Time) {
+	j.init(now)
+	md := math.Min(1, math.Max(0, j.vary(j.MultiplicativeDecrease, j.DecreaseJitter)))
+	j.l.SetLimitAt(now, rate.Limit(float64(j.l.Limit())*md))
+}
+
+// AttemptReserve tries to reserve a request inside the current time window
+func (j *JitteredAIMD) AttemptReserve(now time.Time) bool {
+	j.init(now)
+	return j.AIMD.AttemptReserve(now)
+}
+
+// ReserveWithin reserves a request that the current rate allows within maxWait
+func (j *JitteredAIMD) ReserveWithin(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	j.init(now)
+	return j.AIMD.ReserveWithin(now, maxWait)
+}
+
+// ReserveN reserves n requests like rate.Limiter.ReserveN
+func (j *JitteredAIMD) ReserveN(now time.Time, n int) *rate.Reservation {
+	j.init(now)
+	return j.AIMD.ReserveN(now, n)
+}
+
+// OnHint jumps to the hinted rate, and denies every request until the hinted pause is over
+func (j *JitteredAIMD) OnHint(now time.Time, hint Hint) {
+	j.init(now)
+	j.AIMD.OnHint(now, hint)
+}
+
+var _ RateLimiter = &JitteredAIMD{}
+var _ RateReporter = &JitteredAIMD{}
+var _ RateHinter = &JitteredAIMD{}
+var _ DelayReserver = &JitteredAIMD{}
+var _ Reserver = &JitteredAIMD{}
@@ -0,0 +1,138 @@
+package aimdcloser
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestJitteredAIMDConstructor(t *testing.T) {
+	j := JitteredAIMDConstructor(1, .5, 10, 5, .2)().(*JitteredAIMD)
+	equalFloat(t, .2, j.IncreaseJitter)
+	equalFloat(t, .2, j.DecreaseJitter)
+	equalFloat(t, .2, j.ResetJitter)
+	equalInt(t, 5, j.MaxBurst())
+}
+
+func TestJitteredAIMD_noJitter(t *testing.T) {
+	j := &JitteredAIMD{AIMD: AIMD{AdditiveIncrease: 1, MultiplicativeDecrease: .5, InitialRate: 10, Burst: 1}}
+	now := time.Now()
+	j.OnSuccess(now)
+	equalFloat(t, 11, j.Rate())
+	j.OnFailure(now)
+	equalFloat(t, 5.5, j.Rate())
+	j.Reset(now)
+	equalFloat(t, 10, j.Rate())
+}
+
+func TestJitteredAIMD_bounds(t *testing.T) {
+	j := &JitteredAIMD{
+		AIMD:           AIMD{AdditiveIncrease: 10, MultiplicativeDecrease: .5, InitialRate: 100, Burst: 1},
+		IncreaseJitter: .5,
+		DecreaseJitter: .5,
+		ResetJitter:    .5,
+		Rand:           rand.New(rand.NewSource(1)).Float64,
+	}
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		j.Reset(now)
+		expect(t, j.Rate() > 50 && j.Rate() <= 100, "expected reset to start at most half below InitialRate")
+		before := j.Rate()
+		j.OnSuccess(now)
+		inc := j.Rate() - before
+		expect(t, inc >= 5 && inc <= 15, "expected increase within jitter")
+		before = j.Rate()
+		j.OnFailure(now)
+		md := j.Rate() / before
+		expect(t, md >= .25 && md <= .75, "expected decrease within jitter")
+	}
+	expect(t, j.AttemptReserve(now), "expected to reserve")
+}
+
+func TestJitteredAIMD_repeatable(t *testing.T) {
+	run := func() float64 {
+		j := JitteredAIMDConstructor(1, .5, 10, 1, .3)().(*JitteredAIMD)
+		j.Rand = rand.New(rand.NewSource(42)).Float64
+		now := time.Now()
+		for i := 0; i < 50; i++ {
+			if i%7 == 0 {
+				j.OnFailure(now)
+			} else {
+				j.OnSuccess(now)
+			}
+		}
+		return j.Rate()
+	}
+	equalFloat(t, run(), run())
+}
+
+// syncStats describes how in step a simulation's clients were
+type syncStats struct {
+	// spread is the average coefficient of variation of client rates.  Zero is perfect lockstep.
+	spread float64
+	// restartPeak is the combined rate right after every client resets together
+	restartPeak float64
+}
+
+// simulateClients runs clients against one backend.  Every tick, each client adds its rate to the load.  A load
+// over capacity fails every client, which is the worst case: all of them see the overload together.  Halfway
+// through, the backend has an outage and every client resets at once.
+func simulateClients(clients []RateLimiter, capacity float64, ticks int) syncStats {
+	now := time.Now()
+	for _, c := range clients {
+		c.Reset(now)
+	}
+	var ret syncStats
+	rates := make([]float64, len(clients))
+	for tick := 0; tick < ticks; tick++ {
+		now = now.Add(time.Millisecond * 100)
+		if tick == ticks/2 {
+			load := 0.0
+			for _, c := range clients {
+				c.Reset(now)
+				load += c.(RateReporter).Rate()
+			}
+			ret.restartPeak = load
+		}
+		load := 0.0
+		for i, c := range clients {
+			rates[i] = c.(RateReporter).Rate()
+			load += rates[i]
+		}
+		mean := load / float64(len(clients))
+		variance := 0.0
+		for _, r := range rates {
+			variance += (r - mean) * (r - mean)
+		}
+		ret.spread += math.Sqrt(variance/float64(len(clients))) / mean
+		for _, c := range clients {
+			if load > capacity {
+				c.OnFailure(now)
+			} else {
+				c.OnSuccess(now)
+			}
+		}
+	}
+	ret.spread /= float64(ticks)
+	return ret
+}
+
+func TestJitteredAIMD_simulation(t *testing.T) {
+	const numClients = 20
+	plain := make([]RateLimiter, numClients)
+	jittered := make([]RateLimiter, numClients)
+	r := rand.New(rand.NewSource(7))
+	for i := range plain {
+		plain[i] = AIMDConstructor(2, .5, 50, 10)()
+		j := JitteredAIMDConstructor(2, .5, 50, 10, .3)().(*JitteredAIMD)
+		j.Rand = r.Float64
+		jittered[i] = j
+	}
+	p := simulateClients(plain, 1000, 2000)
+	j := simulateClients(jittered, 1000, 2000)
+	t.Logf("plain: %+v jittered: %+v", p, j)
+	expect(t, p.spread < .0001, "expected plain AIMD clients to move in lockstep")
+	expect(t, j.spread > .05, "expected jittered clients to drift apart")
+	expect(t, j.restartPeak < p.restartPeak, "expected jittered resets to spike less after an outage")
+}
@@ -0,0 +1,206 @@
+package aimdcloser
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Keyed holds one RateLimiter per key, for example per tenant or per user.  Limiters are created the first time a
+// key is used and evicted once idle for IdleTTL, or once there are more than MaxKeys keys.  It is safe for
+// concurrent use: keys are spread over Shards independently locked shards.
+type Keyed struct {
+	// RateLimiter constructs the limiter of a new key.  For example, AIMDConstructor(1, .5, 10, 10).
+	RateLimiter func() RateLimiter
+	// IdleTTL evicts keys that have not been used for this long.  A default of zero never evicts idle keys.
+	IdleTTL time.Duration
+	// MaxKeys evicts the least recently used keys once there are more than this many.  The bound is split over the
+	// shards, so keys are evicted from a full shard even while other shards have room.  A default of zero does not
+	// bound keys.
+	MaxKeys int
+	// Shards is how many independently locked shards keys are spread over.  Defaults to 64, and is never more than
+	// MaxKeys, so every shard keeping at least one key does not exceed MaxKeys.
+	Shards int
+
+	once   sync.Once
+	shards []keyedShard
+}
+
+type keyedShard struct {
+	mu sync.Mutex
+	// maxKeys is this shard's part of MaxKeys, or zero if keys are not bounded
+	maxKeys int
+	// items points into lru, which has the most recently used key at the front
+	items map[string]*list.Element
+	lru   list.List
+}
+
+type keyedEntry struct {
+	key      string
+	limiter  RateLimiter
+	lastUsed time.Time
+}
+
+const defaultKeyedShards = 64
+
+func (k *Keyed) init() {
+	k.once.Do(func() {
+		shards := k.Shards
+		if shards <= 0 {
+			shards = defaultKeyedShards
+		}
+		if k.MaxKeys > 0 && shards > k.MaxKeys {
+			shards = k.MaxKeys
+		}
+		k.shards = make([]keyedShard, shards)
+		for i := range k.shards {
+			k.shards[i].items = make(map[string]*list.Element)
+			if k.MaxKeys > 0 {
+				// The first MaxKeys%shards shards keep one more key, so the shards add up to exactly MaxKeys
+				k.shards[i].maxKeys = k.MaxKeys / shards
+				if i < k.MaxKeys%shards {
+					k.shards[i].maxKeys++
+				}
+			}
+		}
+	})
+}
+
+// shard picks the shard of a key with an inlined FNV-1a hash, which does not allocate
+func (k *Keyed) shard(key string) *keyedShard {
+	k.init()
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return &k.shards[h%uint32(len(k.shards))]
+}
+
+// lock returns the locked shard of key and the limiter of key, creating it if create is set.  The limiter is nil
+// if key has none.  Callers must unlock the shard.
+func (k *Keyed) lock(key string, now time.Time, create bool) (*keyedShard, RateLimiter) {
+	s := k.shard(key)
+	s.mu.Lock()
+	k.evictIdle(s, now)
+	e, exists := s.items[key]
+	if !exists {
+		if !create {
+			return s, nil
+		}
+		e = s.lru.PushFront(&keyedEntry{
+			key:     key,
+			limiter: k.RateLimiter(),
+		})
+		s.items[key] = e
+		if s.maxKeys > 0 {
+			for s.lru.Len() > s.maxKeys {
+				k.remove(s, s.lru.Back())
+			}
+		}
+	} else {
+		s.lru.MoveToFront(e)
+	}
+	entry := e.Value.(*keyedEntry)
+	entry.lastUsed = now
+	return s, entry.limiter
+}
+
+// evictIdle removes idle keys from the back of a shard.  It must be called with the shard's lock held.
+func (k *Keyed) evictIdle(s *keyedShard, now time.Time) int {
+	if k.IdleTTL <= 0 {
+		return 0
+	}
+	ret := 0
+	for e := s.lru.Back(); e != nil && now.Sub(e.Value.(*keyedEntry).lastUsed) >= k.IdleTTL; e = s.lru.Back() {
+		k.remove(s, e)
+		ret++
+	}
+	return ret
+}
+
+func (k *Keyed) remove(s *keyedShard, e *list.Element) {
+	s.lru.Remove(e)
+	delete(s.items, e.Value.(*keyedEntry).key)
+}
+
+// AttemptReserve tries to reserve a request for key.  Returns if the rate limiter of key allows the request.
+func (k *Keyed) AttemptReserve(key string, now time.Time) bool {
+	s, r := k.lock(key, now, true)
+	defer s.mu.Unlock()
+	return r.AttemptReserve(now)
+}
+
+// OnSuccess increases the rate of key
+func (k *Keyed) OnSuccess(key string, now time.Time) {
+	s, r := k.lock(key, now, true)
+	defer s.mu.Unlock()
+	r.OnSuccess(now)
+}
+
+// OnFailure decreases the rate of key
+func (k *Keyed) OnFailure(key string, now time.Time) {
+	s, r := k.lock(key, now, true)
+	defer s.mu.Unlock()
+	r.OnFailure(now)
+}
+
+// OnHint gives a hint to the limiter of key, if it is a RateHinter
+func (k *Keyed) OnHint(key string, now time.Time, hint Hint) {
+	s, r := k.lock(key, now, true)
+	defer s.mu.Unlock()
+	ApplyHint(r, now, hint)
+}
+
+// Reset resets the limiter of key, if it exists
+func (k *Keyed) Reset(key string, now time.Time) {
+	s, r := k.lock(key, now, false)
+	defer s.mu.Unlock()
+	if r != nil {
+		r.Reset(now)
+	}
+}
+
+// Rate returns the current rate of key, and false if key has no limiter or its limiter is not a RateReporter.
+// It does not count as using key.
+func (k *Keyed) Rate(key string) (float64, bool) {
+	s := k.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, exists := s.items[key]
+	if !exists {
+		return 0, false
+	}
+	r, ok := e.Value.(*keyedEntry).limiter.(RateReporter)
+	if !ok {
+		return 0, false
+	}
+	return r.Rate(), true
+}
+
+// EvictIdle removes every key idle for IdleTTL and returns how many were removed.  Idle keys are also removed as
+// other keys are used, so calling this is only needed to release memory when traffic stops.
+func (k *Keyed) EvictIdle(now time.Time) int {
+	k.init()
+	ret := 0
+	for i := range k.shards {
+		s := &k.shards[i]
+		s.mu.Lock()
+		ret += k.evictIdle(s, now)
+		s.mu.Unlock()
+	}
+	return ret
+}
+
+// Len returns how many keys currently have a limiter
+func (k *Keyed) Len() int {
+	k.init()
+	ret := 0
+	for i := range k.shards {
+		s := &k.shards[i]
+		s.mu.Lock()
+		ret += len(s.items)
+		s.mu.Unlock()
+	}
+	return ret
+}
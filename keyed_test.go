@@ -0,0 +1,199 @@
+package aimdcloser
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyed_lazy(t *testing.T) {
+	k := Keyed{
+		RateLimiter: AIMDConstructor(1, .5, 1, 1),
+	}
+	now := time.Now()
+	equalInt(t, 0, k.Len())
+	_, exists := k.Rate("a")
+	expect(t, !exists, "expected no limiter before use")
+	expect(t, k.AttemptReserve("a", now), "expected a new key to allow a request")
+	expect(t, !k.AttemptReserve("a", now), "expected the key's burst to be used")
+	expect(t, k.AttemptReserve("b", now), "expected each key to have its own limiter")
+	equalInt(t, 2, k.Len())
+}
+
+func TestKeyed_feedback(t *testing.T) {
+	k := Keyed{
+		RateLimiter: AIMDConstructor(1, .5, 4, 1),
+	}
+	now := time.Now()
+	k.OnFailure("a", now)
+	k.OnSuccess("b", now)
+	rate, _ := k.Rate("a")
+	equalFloat(t, 2, rate)
+	rate, _ = k.Rate("b")
+	equalFloat(t, 5, rate)
+	k.Reset("a", now)
+	rate, _ = k.Rate("a")
+	equalFloat(t, 4, rate)
+	k.Reset("c", now)
+	equalInt(t, 2, k.Len())
+}
+
+func TestKeyed_IdleTTL(t *testing.T) {
+	k := Keyed{
+		RateLimiter: AIMDConstructor(1, .5, 4, 1),
+		IdleTTL:     time.Minute,
+		Shards:      1,
+	}
+	now := time.Now()
+	k.OnFailure("a", now)
+	k.OnFailure("b", now.Add(time.Second*30))
+	k.AttemptReserve("c", now.Add(time.Minute))
+	_, exists := k.Rate("a")
+	expect(t, !exists, "expected idle key to be evicted when another key is used")
+	_, exists = k.Rate("b")
+	expect(t, exists, "expected key used recently to stay")
+	equalInt(t, 1, k.EvictIdle(now.Add(time.Second*90)))
+	equalInt(t, 1, k.Len())
+	equalInt(t, 1, k.EvictIdle(now.Add(time.Hour)))
+	equalInt(t, 0, k.Len())
+}
+
+func TestKeyed_MaxKeys(t *testing.T) {
+	k := Keyed{
+		RateLimiter: AIMDConstructor(1, .5, 4, 1),
+		MaxKeys:     2,
+		Shards:      1,
+	}
+	now := time.Now()
+	k.OnFailure("a", now)
+	k.OnFailure("b", now)
+	k.OnFailure("a", now)
+	k.OnFailure("c", now)
+	equalInt(t, 2, k.Len())
+	_, exists := k.Rate("b")
+	expect(t, !exists, "expected least recently used key to be evicted")
+	_, exists = k.Rate("a")
+	expect(t, exists, "expected recently used key to stay")
+}
+
+func TestKeyed_MaxKeysSharded(t *testing.T) {
+	k := Keyed{
+		RateLimiter: AIMDConstructor(1, .5, 4, 1),
+		MaxKeys:     1000,
+		Shards:      10,
+	}
+	now := time.Now()
+	for i := 0; i < 10000; i++ {
+		k.AttemptReserve(strconv.Itoa(i), now)
+	}
+	expect(t, k.Len() <= 1000, "expected MaxKeys to bound keys")
+	expect(t, k.Len() > 900, "expected keys to spread over shards")
+}
+
+func TestKeyed_MaxKeysNotDivisible(t *testing.T) {
+	k := Keyed{
+		RateLimiter: AIMDConstructor(1, .5, 4, 1),
+		MaxKeys:     100,
+	}
+	now := time.Now()
+	for i := 0; i < 10000; i++ {
+		k.AttemptReserve(strconv.Itoa(i), now)
+	}
+	equalInt(t, 100, k.Len())
+}
+
+func TestKeyed_MaxKeysFewerThanShards(t *testing.T) {
+	k := Keyed{
+		RateLimiter: AIMDConstructor(1, .5, 4, 1),
+		MaxKeys:     10,
+	}
+	now := time.Now()
+	for i := 0; i < 1000; i++ {
+		k.AttemptReserve(strconv.Itoa(i), now)
+	}
+	expect(t, k.Len() <= 10, "expected MaxKeys to bound keys with the default shards, got "+strconv.Itoa(k.Len()))
+}
+
+func TestKeyed_concurrent(t *testing.T) {
+	k := Keyed{
+		RateLimiter: AIMDConstructor(1, .5, 1000, 10),
+		IdleTTL:     time.Millisecond,
+		MaxKeys:     50,
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := strconv.Itoa((i * j) % 100)
+				now := time.Now()
+				if k.AttemptReserve(key, now) {
+					k.OnSuccess(key, now)
+				} else {
+					k.OnFailure(key, now)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	k.EvictIdle(time.Now().Add(time.Second))
+	equalInt(t, 0, k.Len())
+}
+
+func BenchmarkKeyed_AttemptReserve(b *testing.B) {
+	k := Keyed{
+		RateLimiter: AIMDConstructor(1, .5, 1000, 10),
+	}
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	now := time.Now()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			k.AttemptReserve(keys[i%len(keys)], now)
+			i++
+		}
+	})
+}
+
+// BenchmarkKeyed_Memory reports the heap used per key with a million keys, each with a used AIMD
+func BenchmarkKeyed_Memory(b *testing.B) {
+	const numKeys = 1000000
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	now := time.Now()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		k := &Keyed{
+			RateLimiter: AIMDConstructor(1, .5, 1000, 10),
+		}
+		for _, key := range keys {
+			k.AttemptReserve(key, now)
+		}
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		b.Logf("%.1f heap bytes/key", float64(after.HeapAlloc-before.HeapAlloc)/numKeys)
+		runtime.KeepAlive(k)
+	}
+}
+
+func TestKeyed_OnHint(t *testing.T) {
+	k := Keyed{RateLimiter: AIMDConstructor(1, .5, 10, 1)}
+	now := time.Now()
+	k.OnHint("a", now, Hint{PauseUntil: now.Add(time.Minute)})
+	expect(t, !k.AttemptReserve("a", now), "expected a to be paused")
+	expect(t, k.AttemptReserve("b", now), "expected b to be unaffected")
+}
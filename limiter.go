@@ -0,0 +1,184 @@
+package aimdcloser
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limiter has the method set of *rate.Limiter, backed by an adaptive RateLimiter, so code written against
+// *rate.Limiter can adapt its rate by changing a type and reporting results with ReportSuccess and ReportFailure.
+// Without any reports it behaves like a rate.Limiter at the initial rate and burst of RateLimiter.
+//
+// RateLimiter should be a Reserver, like AIMD.  Other rate limiters can only be used one request at a time through
+// Allow, AllowN and Wait, and their reservations are never OK.  Limiter is safe for concurrent use.  RateLimiter does
+// not need to be, as long as only the Limiter uses it.
+type Limiter struct {
+	RateLimiter RateLimiter
+	mu          sync.Mutex
+}
+
+// NewLimiter returns a Limiter that starts like rate.NewLimiter(r, b), and learns its rate with an AIMD that adds
+// additiveIncrease on each success and multiplies by multiplicativeDecrease on each failure.
+func NewLimiter(r rate.Limit, b int, additiveIncrease float64, multiplicativeDecrease float64) *Limiter {
+	return &Limiter{
+		RateLimiter: &AIMD{
+			AdditiveIncrease:       additiveIncrease,
+			MultiplicativeDecrease: multiplicativeDecrease,
+			InitialRate:            float64(r),
+			Burst:                  b,
+		},
+	}
+}
+
+// Limit returns the current rate
+func (l *Limiter) Limit() rate.Limit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if r, ok := l.RateLimiter.(RateReporter); ok {
+		if rt := r.Rate(); rt < float64(rate.Inf) {
+			return rate.Limit(rt)
+		}
+	}
+	return rate.Inf
+}
+
+// Burst returns the maximum burst size
+func (l *Limiter) Burst() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if r, ok := l.RateLimiter.(RateReporter); ok {
+		return r.MaxBurst()
+	}
+	return 0
+}
+
+// Allow is shorthand for AllowN(time.Now(), 1)
+func (l *Limiter) Allow() bool {
+	return l.AllowN(time.Now(), 1)
+}
+
+// AllowN reports whether n requests may happen at now, and uses them if so
+func (l *Limiter) AllowN(now time.Time, n int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if r, ok := l.RateLimiter.(Reserver); ok {
+		res := r.ReserveN(now, n)
+		if !res.OK() {
+			return false
+		}
+		if res.DelayFrom(now) > 0 {
+			res.CancelAt(now)
+			return false
+		}
+		return true
+	}
+	if n == 1 {
+		return l.RateLimiter.AttemptReserve(now)
+	}
+	return n <= 0
+}
+
+// Reserve is shorthand for ReserveN(time.Now(), 1)
+func (l *Limiter) Reserve() *rate.Reservation {
+	return l.ReserveN(time.Now(), 1)
+}
+
+// ReserveN reserves n requests at now, like rate.Limiter.ReserveN.  Call Cancel on the reservation to give the
+// requests back if they are not made.
+func (l *Limiter) ReserveN(now time.Time, n int) *rate.Reservation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if r, ok := l.RateLimiter.(Reserver); ok {
+		return r.ReserveN(now, n)
+	}
+	return notOK(now)
+}
+
+// Wait is shorthand for WaitN(ctx, 1)
+func (l *Limiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n requests are allowed.  It returns an error if n is more than Burst, if the wait would pass
+// the deadline of ctx, or if ctx ends first.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	now := time.Now()
+	waitLimit := time.Duration(math.MaxInt64)
+	if deadline, ok := ctx.Deadline(); ok {
+		waitLimit = deadline.Sub(now)
+	}
+	wait, cancel, err := l.reserveWait(now, n, waitLimit)
+	if err != nil || wait <= 0 {
+		return err
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		cancel()
+		return ctx.Err()
+	}
+}
+
+// reserveWait reserves n requests allowed within waitLimit, and returns how long to wait for them and how to give
+// them back.
+func (l *Limiter) reserveWait(now time.Time, n int, waitLimit time.Duration) (time.Duration, func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if r, ok := l.RateLimiter.(RateReporter); ok && n > r.MaxBurst() && r.Rate() < float64(rate.Inf) {
+		return 0, nil, fmt.Errorf("aimdcloser: Wait(n=%d) exceeds limiter's burst %d", n, r.MaxBurst())
+	}
+	if r, ok := l.RateLimiter.(Reserver); ok {
+		res := r.ReserveN(now, n)
+		if !res.OK() {
+			return 0, nil, fmt.Errorf("aimdcloser: Wait(n=%d) is not allowed", n)
+		}
+		wait := res.DelayFrom(now)
+		if wait > waitLimit {
+			res.CancelAt(now)
+			return 0, nil, fmt.Errorf("aimdcloser: Wait(n=%d) would exceed context deadline", n)
+		}
+		return wait, res.Cancel, nil
+	}
+	if n != 1 {
+		return 0, nil, fmt.Errorf("aimdcloser: Wait(n=%d) needs a Reserver", n)
+	}
+	if d, ok := l.RateLimiter.(DelayReserver); ok {
+		wait, ok := d.ReserveWithin(now, waitLimit)
+		if !ok {
+			return 0, nil, fmt.Errorf("aimdcloser: Wait(n=%d) would exceed context deadline", n)
+		}
+		// A DelayReserver cannot give a reservation back
+		return wait, func() {}, nil
+	}
+	if !l.RateLimiter.AttemptReserve(now) {
+		return 0, nil, fmt.Errorf("aimdcloser: Wait(n=%d) is not allowed", n)
+	}
+	return 0, nil, nil
+}
+
+// ReportSuccess tells RateLimiter a request succeeded, which usually increases the rate
+func (l *Limiter) ReportSuccess() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.RateLimiter.OnSuccess(time.Now())
+}
+
+// ReportFailure tells RateLimiter a request failed, which usually decreases the rate
+func (l *Limiter) ReportFailure() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.RateLimiter.OnFailure(time.Now())
+}
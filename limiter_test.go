@@ -0,0 +1,115 @@
+package aimdcloser
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Without ReportSuccess or ReportFailure, a Limiter should behave like the rate.Limiter it replaces.  Each test
+// runs the same calls against both.
+
+func newLimiters() (*rate.Limiter, *Limiter) {
+	return rate.NewLimiter(10, 3), NewLimiter(10, 3, 1, .5)
+}
+
+func TestLimiter_LimitBurst(t *testing.T) {
+	expected, given := newLimiters()
+	equalFloat(t, float64(expected.Limit()), float64(given.Limit()))
+	equalInt(t, expected.Burst(), given.Burst())
+	// A rate limiter that reports nothing is unlimited
+	l := &Limiter{RateLimiter: neverLimits{}}
+	expect(t, l.Limit() == rate.Inf, "expected an infinite limit")
+	equalInt(t, 0, l.Burst())
+}
+
+// AllowN uses n requests only when they are all available at now.  Requests more than the burst are never allowed,
+// and zero requests always are.
+func TestLimiter_AllowN(t *testing.T) {
+	expected, given := newLimiters()
+	now := time.Now()
+	steps := []struct {
+		after time.Duration
+		n     int
+	}{
+		{0, 1}, {0, 2}, {0, 1}, {0, 0}, {50 * time.Millisecond, 1}, {100 * time.Millisecond, 1},
+		{100 * time.Millisecond, 4}, {time.Second, 3}, {time.Second, 1}, {2 * time.Second, 2}, {2 * time.Second, 2},
+	}
+	for _, s := range steps {
+		at := now.Add(s.after)
+		if e, g := expected.AllowN(at, s.n), given.AllowN(at, s.n); e != g {
+			t.Errorf("AllowN(+%s, %d): expected %t given %t", s.after, s.n, e, g)
+		}
+	}
+}
+
+// Allow is AllowN at the current time for a single request.
+func TestLimiter_Allow(t *testing.T) {
+	expected, given := newLimiters()
+	for i := 0; i < 5; i++ {
+		if e, g := expected.Allow(), given.Allow(); e != g {
+			t.Errorf("Allow %d: expected %t given %t", i, e, g)
+		}
+	}
+}
+
+// ReserveN always reserves, telling the caller how long to wait.  It is not OK only for more than the burst.
+// Cancelling gives the requests back.
+func TestLimiter_ReserveN(t *testing.T) {
+	expected, given := newLimiters()
+	now := time.Now()
+	for _, n := range []int{3, 2, 1, 4} {
+		e, g := expected.ReserveN(now, n), given.ReserveN(now, n)
+		expect(t, e.OK() == g.OK(), "expected the same OK")
+		expect(t, e.DelayFrom(now) == g.DelayFrom(now), "expected the same delay")
+	}
+	e, g := expected.ReserveN(now, 1), given.ReserveN(now, 1)
+	e.CancelAt(now)
+	g.CancelAt(now)
+	expect(t, expected.ReserveN(now, 1).DelayFrom(now) == given.ReserveN(now, 1).DelayFrom(now), "expected cancel to give requests back")
+	expect(t, given.Reserve().OK(), "expected Reserve to reserve a request")
+	// Rate limiters that are not Reservers never give OK reservations
+	l := &Limiter{RateLimiter: neverLimits{}}
+	expect(t, !l.Reserve().OK(), "expected no reservation")
+	expect(t, l.Allow(), "expected a single request to still be allowed")
+	expect(t, !l.AllowN(time.Now(), 2), "expected many requests to need a Reserver")
+	expectNilErr(t, l.Wait(context.Background()))
+}
+
+// WaitN returns nil once n requests are allowed, and an error without waiting if n is more than the burst, if ctx is
+// done, or if the wait would pass the deadline of ctx.
+func TestLimiter_WaitN(t *testing.T) {
+	expected, given := newLimiters()
+	ctx := context.Background()
+	for _, n := range []int{3, 4} {
+		e, g := expected.WaitN(ctx, n), given.WaitN(ctx, n)
+		expect(t, (e == nil) == (g == nil), "expected the same error")
+	}
+	// Next request is 100ms away
+	short, cancel := context.WithTimeout(ctx, time.Millisecond*10)
+	defer cancel()
+	e, g := expected.Wait(short), given.Wait(short)
+	expect(t, e != nil && g != nil, "expected waits past the deadline to fail")
+	done, cancel := context.WithCancel(ctx)
+	cancel()
+	e, g = expected.Wait(done), given.Wait(done)
+	expect(t, e == context.Canceled && g == context.Canceled, "expected done contexts to fail")
+	start := time.Now()
+	expectNilErr(t, given.Wait(ctx))
+	expect(t, time.Since(start) >= time.Millisecond*50, "expected Wait to wait for the rate")
+}
+
+func TestLimiter_Report(t *testing.T) {
+	l := NewLimiter(10, 3, 1, .5)
+	l.ReportSuccess()
+	equalFloat(t, 11, float64(l.Limit()))
+	l.ReportFailure()
+	equalFloat(t, 5.5, float64(l.Limit()))
+	// Requests during a pause are refused
+	ApplyHint(l.RateLimiter, time.Now(), Hint{PauseUntil: time.Now().Add(time.Hour)})
+	expect(t, !l.Allow(), "expected a paused limiter to refuse requests")
+	expect(t, !l.Reserve().OK(), "expected a paused limiter to refuse reservations")
+	expect(t, l.Wait(context.Background()) != nil, "expected a paused limiter to refuse waits")
+}
@@ -0,0 +1,231 @@
+package aimdcloser
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// PriorityReserver is optionally implemented by a RateLimiter that admits requests differently by priority.  Higher
+// priorities are more important.
+type PriorityReserver interface {
+	// AttemptReservePriority is AttemptReserve for a request of the given priority
+	AttemptReservePriority(now time.Time, priority int) bool
+}
+
+// PriorityAIMD is an AIMD whose learned rate is shared by priority classes, from 0 (least important) up to
+// len(Reserved)-1.  Reserved[p] is the fraction (0.0, 1.0) of the rate and burst that priorities below p may never
+// use, so as the rate drops lower priorities are shed first while higher ones keep their share.  Each priority's part
+// of the burst is rounded up, so every priority with any share can make a request.  Priorities outside
+// of that range are treated as the closest one in it.
+// It is *NOT* thread safe
+type PriorityAIMD struct {
+	AIMD
+	// Reserved is the share of the rate held back for each priority and above.  Reserved[0] has no effect, since no
+	// priority is below it.
+	Reserved []float64
+	// DefaultPriority is the priority of AttemptReserve, ReserveWithin and ReserveN
+	DefaultPriority int
+
+	// ceilings[p] limits priority p and every priority below it to the part of the rate they may use.  A nil
+	// ceiling is not limited.
+	ceilings []*rate.Limiter
+}
+
+// PriorityAIMDConstructor constructs rate limiters according to the given parameters.  See documentation for AIMD
+// and PriorityAIMD for what each parameter means.
+func PriorityAIMDConstructor(additiveIncrease float64, multiplicativeDecrease float64, initialRate float64, burst int, reserved []float64, defaultPriority int) func() RateLimiter {
+	return func() RateLimiter {
+		return &PriorityAIMD{
+			AIMD: AIMD{
+				AdditiveIncrease:       additiveIncrease,
+				MultiplicativeDecrease: multiplicativeDecrease,
+				InitialRate:            initialRate,
+				Burst:                  burst,
+			},
+			Reserved:        reserved,
+			DefaultPriority: defaultPriority,
+		}
+	}
+}
+
+// ceiling is the fraction of the rate that priority may use
+func (p *PriorityAIMD) ceiling(priority int) float64 {
+	ret := 1.0
+	for _, r := range p.Reserved[priority+1:] {
+		ret -= r
+	}
+	return math.Max(0, ret)
+}
+
+// ceilingLimiters returns the limiters of every priority, each at its share of the current rate.  The limiter of a
+// priority counts requests of that priority and every priority below it.  A nil limiter is not limited.
+func (p *PriorityAIMD) ceilingLimiters(now time.Time) []*rate.Limiter {
+	if p.ceilings == nil {
+		p.ceilings = make([]*rate.Limiter, len(p.Reserved))
+		for i := range p.ceilings {
+			if c := p.ceiling(i); c < 1 {
+				// A limiter created with a zero limit never fills its burst, so start at the current share
+				p.ceilings[i] = rate.NewLimiter(p.ceilingLimit(c), int(math.Ceil(float64(p.Burst)*c)))
+			}
+		}
+	}
+	for i, lim := range p.ceilings {
+		if lim != nil {
+			lim.SetLimitAt(now, p.ceilingLimit(p.ceiling(i)))
+		}
+	}
+	return p.ceilings
+}
+
+// ceilingLimit is the share c of the current rate.  Every share of an unlimited rate is unlimited, except a share
+// of zero.
+func (p *PriorityAIMD) ceilingLimit(c float64) rate.Limit {
+	r := p.AIMD.Rate()
+	if math.IsInf(r, 1) {
+		if c == 0 {
+			return 0
+		}
+		return rate.Inf
+	}
+	return rate.Limit(r * c)
+}
+
+// clamp returns the priority in Reserved that priority is treated as
+func (p *PriorityAIMD) clamp(priority int) int {
+	if priority < 0 {
+		return 0
+	}
+	if priority >= len(p.Reserved) {
+		return len(p.Reserved) - 1
+	}
+	return priority
+}
+
+// reserveCeilings reserves n requests from the shares of priority and every priority above it.  It returns the
+// reservations and the longest delay among them, or false after cancelling them if a share can never allow n.
+func (p *PriorityAIMD) reserveCeilings(now time.Time, priority int, n int) ([]*rate.Reservation, time.Duration, bool) {
+	var taken []*rate.Reservation
+	var wait time.Duration
+	for _, lim := range p.ceilingLimiters(now)[priority:] {
+		if lim == nil {
+			continue
+		}
+		r := lim.ReserveN(now, n)
+		if !r.OK() {
+			cancelAll(now, taken)
+			return nil, 0, false
+		}
+		taken = append(taken, r)
+		if d := r.DelayFrom(now); d > wait {
+			wait = d
+		}
+	}
+	return taken, wait, true
+}
+
+func cancelAll(now time.Time, reservations []*rate.Reservation) {
+	for _, r := range reservations {
+		r.CancelAt(now)
+	}
+}
+
+// AttemptReservePriority reserves a request of priority if the shares of priority and every priority above it, and
+// the whole rate, allow it.
+func (p *PriorityAIMD) AttemptReservePriority(now time.Time, priority int) bool {
+	if len(p.Reserved) == 0 {
+		return p.AIMD.AttemptReserve(now)
+	}
+	p.AIMD.init(now)
+	taken, wait, ok := p.reserveCeilings(now, p.clamp(priority), 1)
+	if !ok {
+		return false
+	}
+	if wait > 0 || !p.AIMD.AttemptReserve(now) {
+		cancelAll(now, taken)
+		return false
+	}
+	return true
+}
+
+// AttemptReserve reserves a request of DefaultPriority
+func (p *PriorityAIMD) AttemptReserve(now time.Time) bool {
+	return p.AttemptReservePriority(now, p.DefaultPriority)
+}
+
+// ReserveWithin reserves a request of DefaultPriority if its share and the whole rate allow it within maxWait
+func (p *PriorityAIMD) ReserveWithin(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	if len(p.Reserved) == 0 {
+		return p.AIMD.ReserveWithin(now, maxWait)
+	}
+	p.AIMD.init(now)
+	taken, wait, ok := p.reserveCeilings(now, p.clamp(p.DefaultPriority), 1)
+	if !ok {
+		return 0, false
+	}
+	r := p.AIMD.ReserveN(now, 1)
+	if !r.OK() {
+		cancelAll(now, taken)
+		return 0, false
+	}
+	taken = append(taken, r)
+	if d := r.DelayFrom(now); d > wait {
+		wait = d
+	}
+	if wait > maxWait {
+		cancelAll(now, taken)
+		return 0, false
+	}
+	return wait, true
+}
+
+// ReserveN reserves n requests of DefaultPriority.  The returned reservation is the one of the whole rate, so it is
+// not OK if the share of DefaultPriority would make it wait longer than the whole rate does.  Cancelling it gives
+// back only the whole rate's part, leaving the share charged.
+func (p *PriorityAIMD) ReserveN(now time.Time, n int) *rate.Reservation {
+	if len(p.Reserved) == 0 {
+		return p.AIMD.ReserveN(now, n)
+	}
+	p.AIMD.init(now)
+	taken, wait, ok := p.reserveCeilings(now, p.clamp(p.DefaultPriority), n)
+	if !ok {
+		return notOK(now)
+	}
+	r := p.AIMD.ReserveN(now, n)
+	if !r.OK() || r.DelayFrom(now) < wait {
+		if r.OK() {
+			r.CancelAt(now)
+		}
+		cancelAll(now, taken)
+		return notOK(now)
+	}
+	return r
+}
+
+// Reset the learned rate and every priority's share of it
+func (p *PriorityAIMD) Reset(now time.Time) {
+	p.AIMD.Reset(now)
+	p.ceilings = nil
+}
+
+var _ RateLimiter = &PriorityAIMD{}
+var _ RateReporter = &PriorityAIMD{}
+var _ PriorityReserver = &PriorityAIMD{}
+var _ DelayReserver = &PriorityAIMD{}
+var _ Reserver = &PriorityAIMD{}
+
+type priorityKey struct{}
+
+// WithPriority returns a context that carries the priority of a request.  Guard uses it for limiters that are a
+// PriorityReserver.
+func WithPriority(ctx context.Context, priority int) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// PriorityFromContext returns the priority of ctx, and false if it has none
+func PriorityFromContext(ctx context.Context) (int, bool) {
+	p, ok := ctx.Value(priorityKey{}).(int)
+	return p, ok
+}
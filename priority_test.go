@@ -0,0 +1,153 @@
+package aimdcloser
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestPriorityAIMDConstructor(t *testing.T) {
+	p := PriorityAIMDConstructor(1, .5, 10, 4, []float64{0, .5}, 1)().(*PriorityAIMD)
+	equalFloat(t, 10, p.Rate())
+	equalInt(t, 4, p.MaxBurst())
+	equalInt(t, 1, p.DefaultPriority)
+	equalFloat(t, .5, p.ceiling(0))
+	equalFloat(t, 1, p.ceiling(1))
+}
+
+func TestPriorityAIMD_reservedBurst(t *testing.T) {
+	p := &PriorityAIMD{
+		AIMD:     AIMD{InitialRate: 10, Burst: 10},
+		Reserved: []float64{0, .2, .3},
+	}
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		expect(t, p.AttemptReservePriority(now, 0), "expected priority 0 to use half the burst")
+	}
+	expect(t, !p.AttemptReservePriority(now, 0), "expected priority 0 to be shed past its half")
+	for i := 0; i < 2; i++ {
+		expect(t, p.AttemptReservePriority(now, 1), "expected priority 1 to use the rest of its 70%")
+	}
+	expect(t, !p.AttemptReservePriority(now, 1), "expected priority 1 to be shed past its 70%")
+	for i := 0; i < 3; i++ {
+		expect(t, p.AttemptReservePriority(now, 2), "expected priority 2 to use the reserved 30%")
+	}
+	expect(t, !p.AttemptReservePriority(now, 5), "expected the whole burst to be used")
+}
+
+func TestPriorityAIMD_infiniteRate(t *testing.T) {
+	p := &PriorityAIMD{
+		AIMD:     AIMD{InitialRate: math.Inf(1), Burst: 10},
+		Reserved: []float64{0, .5, .5},
+	}
+	now := time.Now()
+	expect(t, !p.AttemptReservePriority(now, 0), "expected a zero share of an unlimited rate to allow nothing")
+	ceilings := p.ceilingLimiters(now)
+	expect(t, ceilings[0].Limit() == 0, "expected a zero share of an unlimited rate to be zero")
+	expect(t, ceilings[1].Limit() == rate.Inf, "expected a share of an unlimited rate to be unlimited")
+	for i := 0; i < 100; i++ {
+		expect(t, p.AttemptReservePriority(now, 1), "expected a share of an unlimited rate to be unlimited")
+		expect(t, p.AttemptReservePriority(now, 2), "expected the whole unlimited rate to be unlimited")
+	}
+}
+
+func TestPriorityAIMD_reserveWithCeilings(t *testing.T) {
+	newLimiter := func() *PriorityAIMD {
+		return &PriorityAIMD{
+			AIMD:     AIMD{InitialRate: 10, Burst: 10},
+			Reserved: []float64{0, .8},
+		}
+	}
+	now := time.Now()
+	p := newLimiter()
+	for i := 0; i < 2; i++ {
+		wait, ok := p.ReserveWithin(now, 0)
+		expect(t, ok && wait == 0, "expected priority 0 to use its 20% of the burst")
+	}
+	_, ok := p.ReserveWithin(now, 0)
+	expect(t, !ok, "expected ReserveWithin to apply the share of the default priority")
+	wait, ok := p.ReserveWithin(now, time.Second)
+	expect(t, ok && wait == time.Millisecond*500, "expected to wait for the share of the default priority to refill")
+
+	p = newLimiter()
+	for i := 0; i < 2; i++ {
+		r := p.ReserveN(now, 1)
+		expect(t, r.OK() && r.DelayFrom(now) == 0, "expected priority 0 to use its 20% of the burst")
+	}
+	expect(t, !p.ReserveN(now, 1).OK(), "expected ReserveN to apply the share of the default priority")
+	expect(t, p.AttemptReservePriority(now, 1), "expected refused reservations to be given back")
+
+	l := &Limiter{RateLimiter: newLimiter()}
+	expect(t, l.AllowN(now, 2), "expected priority 0 to use its 20% of the burst")
+	expect(t, !l.AllowN(now, 1), "expected Limiter to apply the share of the default priority")
+}
+
+func TestPriorityAIMD_sheddingOrder(t *testing.T) {
+	p := &PriorityAIMD{
+		AIMD:     AIMD{MultiplicativeDecrease: .5, InitialRate: 100, Burst: 1},
+		Reserved: []float64{0, .5},
+	}
+	now := time.Now()
+	p.OnFailure(now)
+	equalFloat(t, 50, p.Rate())
+	low := 0
+	high := 0
+	// Both priorities ask far more than the rate allows for 10 seconds
+	for i := 0; i < 10000; i++ {
+		now = now.Add(time.Millisecond)
+		if p.AttemptReservePriority(now, 0) {
+			low++
+		}
+		if p.AttemptReservePriority(now, 1) {
+			high++
+		}
+	}
+	expect(t, low <= 250+1, "expected low priority to use at most its half of the rate")
+	expect(t, high >= 250, "expected high priority to keep its reserved half")
+	expect(t, low+high <= 500+2, "expected the total to stay within the learned rate")
+	t.Logf("low=%d high=%d", low, high)
+}
+
+func TestPriorityAIMD_defaults(t *testing.T) {
+	p := &PriorityAIMD{
+		AIMD: AIMD{InitialRate: 10, Burst: 1},
+	}
+	now := time.Now()
+	expect(t, p.AttemptReserve(now), "expected no priorities to act as AIMD")
+	expect(t, !p.AttemptReservePriority(now, 3), "expected no priorities to act as AIMD")
+
+	p = &PriorityAIMD{
+		AIMD:            AIMD{InitialRate: 10, Burst: 2},
+		Reserved:        []float64{0, .5},
+		DefaultPriority: 0,
+	}
+	expect(t, p.AttemptReserve(now), "expected the default priority to use its share")
+	expect(t, !p.AttemptReserve(now), "expected the default priority to stop at its share")
+	expect(t, p.AttemptReservePriority(now, 99), "expected high priorities to be the highest class")
+	p.Reset(now)
+	expect(t, p.AttemptReservePriority(now, -1), "expected reset to refill every share")
+}
+
+func TestGuard_priority(t *testing.T) {
+	now := time.Now()
+	g := &Guard{
+		RateLimiter: &PriorityAIMD{
+			AIMD:     AIMD{InitialRate: 10, Burst: 2},
+			Reserved: []float64{0, .5},
+		},
+		Now: func() time.Time {
+			return now
+		},
+	}
+	f := func(ctx context.Context) error {
+		return nil
+	}
+	expectNilErr(t, g.Do(context.Background(), f))
+	expect(t, g.Do(context.Background(), f) == ErrRateLimited, "expected the default priority to be shed")
+	expectNilErr(t, g.Do(WithPriority(context.Background(), 1), f))
+	_, ok := PriorityFromContext(context.Background())
+	expect(t, !ok, "expected no priority by default")
+}
@@ -0,0 +1,73 @@
+package ratecloser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+)
+
+func TestCloser_Observe(t *testing.T) {
+	factory := CloserFactory(CloserConfig{
+		RateLimiter:          aimdcloser.AIMDConstructor(1, .5, 100, 10),
+		CloseOnHappyDuration: time.Second,
+	})
+	now := time.Now()
+	single := factory().(*Closer)
+	batched := factory().(*Closer)
+	for i := 0; i < 20; i++ {
+		single.Success(now, 0)
+	}
+	single.ErrFailure(now, 0)
+	single.ErrTimeout(now, 0)
+	single.ErrTimeout(now, 0)
+	batched.Observe(now, aimdcloser.Batch{Successes: 20, Failures: 1, Timeouts: 2})
+	if single.Stats(now).Rate != batched.Stats(now).Rate {
+		t.Fatalf("expected the same rate, got %f and %f", single.Stats(now).Rate, batched.Stats(now).Rate)
+	}
+	if batched.ShouldClose(now.Add(time.Second)) {
+		t.Fatal("expected failures in a batch to restart the happy duration")
+	}
+	batched.Observe(now.Add(time.Second), aimdcloser.Batch{Successes: 5})
+	if !batched.ShouldClose(now.Add(time.Second * 2)) {
+		t.Fatal("expected successes in a batch not to restart the happy duration")
+	}
+}
+
+func TestCloser_ObserveLogs(t *testing.T) {
+	l := &recordingLogger{}
+	c := CloserFactory(CloserConfig{
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 100, 10),
+		Logger:      l,
+	})().(*Closer)
+	c.Observe(time.Now(), aimdcloser.Batch{Failures: 1})
+	if l.count("rate decreased") != 1 {
+		t.Fatal("expected a decrease to be logged")
+	}
+}
+
+// benchmarkCloserReport reports 100 results per op from every goroutine
+func benchmarkCloserReport(b *testing.B, report func(c *Closer, now time.Time)) {
+	c := CloserFactory(CloserConfig{})().(*Closer)
+	now := time.Now()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			report(c, now)
+		}
+	})
+}
+
+func BenchmarkCloser_Success_100(b *testing.B) {
+	benchmarkCloserReport(b, func(c *Closer, now time.Time) {
+		for i := 0; i < 100; i++ {
+			c.Success(now, 0)
+		}
+	})
+}
+
+func BenchmarkCloser_Observe_100(b *testing.B) {
+	benchmarkCloserReport(b, func(c *Closer, now time.Time) {
+		c.Observe(now, aimdcloser.Batch{Successes: 100})
+	})
+}
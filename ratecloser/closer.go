@@ -1,7 +1,9 @@
 package ratecloser
 
 import (
+	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cep21/aimdcloser"
@@ -11,12 +13,49 @@ import (
 
 // Closer is a circuit closer that allows requests according to a rate limiter.
 type Closer struct {
+	// allowed and granted are used atomically, and are first so they are 64-bit aligned
+	allowed int64
+	// granted is len(reservations), so Allow can skip the lock when there are none
+	granted int64
 	// Rater is the rate limiter of this closer
 	Rater aimdcloser.RateLimiter
 	// CloseOnHappyDuration is how long we should see zero failing requests before we close the ratecloser.
 	CloseOnHappyDuration time.Duration
-	lastFailedReserve    time.Time
-	mu                   sync.Mutex
+	// Logger, if set, records state transitions, rate decreases and rejections
+	Logger Logger
+	// RejectionLogInterval is the most often Logger is sent a summary of rejected requests
+	RejectionLogInterval time.Duration
+	// MaxWait is the longest AllowBy may ask a caller to wait.  Zero turns off waiting.
+	MaxWait time.Duration
+	// MinRemaining is how much of a request's deadline must be left once AllowBy's wait is over
+	MinRemaining time.Duration
+	// ConcurrentRater, if true, means Rater is safe for concurrent use, like aimdcloser.Sharded.  Allow and Success
+	// then skip the closer's lock unless a request is rejected.
+	ConcurrentRater   bool
+	lastFailedReserve time.Time
+	rejected          int64
+	logState          logState
+	closedRate        float64
+	// reservations are the requests AllowBy reserved that Allow has not admitted yet
+	reservations []*reservation
+	// open is true between Opened and Closed
+	open bool
+	mu   sync.Mutex
+}
+
+// Stats is a point in time view of a Closer, useful for metrics.
+type Stats struct {
+	// Rate is the current requests / sec of the Rater, or NaN if the Rater is not an aimdcloser.RateReporter.
+	Rate float64
+	// Burst is the current burst of the Rater, or zero if the Rater is not an aimdcloser.RateReporter.
+	Burst int
+	// HappyProgress is the fraction (0.0, 1.0) of CloseOnHappyDuration that has passed without a failure
+	// or a rejected request.
+	HappyProgress float64
+	// Allowed is how many requests Allow has admitted.
+	Allowed int64
+	// Rejected is how many requests Allow has not admitted.
+	Rejected int64
 }
 
 // OpenerConfig configures defaults for Closer.
@@ -27,20 +66,50 @@ type CloserConfig struct {
 	// CloseOnHappyDuration gives a duration that passing requests cause the ratecloser to close.
 	// We default to a reasonable short value.  It happens to be 10 seconds right now.
 	CloseOnHappyDuration time.Duration
+	// Logger, if set, records the closer's decisions.  A *slog.Logger is a valid Logger.  Defaults to no logging.
+	Logger Logger
+	// RejectionLogInterval is how often rejected requests are summarized to Logger.  It happens to be 10 seconds
+	// right now.
+	RejectionLogInterval time.Duration
+	// MaxWait is the longest a request may be asked to wait for the rate limiter, see Closer.AllowBy.  Defaults to
+	// never waiting.
+	MaxWait time.Duration
+	// MinRemaining is how much of a request's deadline must be left after waiting.  Defaults to zero.
+	MinRemaining time.Duration
+	// ConcurrentRater is true if RateLimiter constructs rate limiters safe for concurrent use, like
+	// aimdcloser.ShardedConstructor.  Closers then skip their lock when allowing requests and recording successes.
+	// Defaults to false.
+	ConcurrentRater bool
 }
 
 func (o *CloserConfig) merge(other CloserConfig) {
 	if o.CloseOnHappyDuration == 0 {
 		o.CloseOnHappyDuration = other.CloseOnHappyDuration
 	}
+	if o.Logger == nil {
+		o.Logger = other.Logger
+	}
+	if o.RejectionLogInterval == 0 {
+		o.RejectionLogInterval = other.RejectionLogInterval
+	}
 	if o.RateLimiter == nil {
 		o.RateLimiter = other.RateLimiter
 	}
+	if o.MaxWait == 0 {
+		o.MaxWait = other.MaxWait
+	}
+	if o.MinRemaining == 0 {
+		o.MinRemaining = other.MinRemaining
+	}
+	if !o.ConcurrentRater {
+		o.ConcurrentRater = other.ConcurrentRater
+	}
 }
 
 var defaultConfig = CloserConfig{
 	RateLimiter:          aimdcloser.AIMDConstructor(.1, .5, 1/time.Microsecond.Seconds(), 10),
 	CloseOnHappyDuration: time.Second * 10,
+	RejectionLogInterval: time.Second * 10,
 }
 
 // CloserFactory is injectable into a ratecloser's configuration to create a factory of rate limit closers for a ratecloser.
@@ -51,6 +120,11 @@ func CloserFactory(conf CloserConfig) func() circuit.OpenToClosed {
 		return &Closer{
 			Rater:                c.RateLimiter(),
 			CloseOnHappyDuration: c.CloseOnHappyDuration,
+			Logger:               c.Logger,
+			RejectionLogInterval: c.RejectionLogInterval,
+			MaxWait:              c.MaxWait,
+			MinRemaining:         c.MinRemaining,
+			ConcurrentRater:      c.ConcurrentRater,
 			lastFailedReserve:    time.Now(),
 		}
 	}
@@ -58,6 +132,10 @@ func CloserFactory(conf CloserConfig) func() circuit.OpenToClosed {
 
 // Success sends the rater a success message.
 func (c *Closer) Success(now time.Time, duration time.Duration) {
+	if c.ConcurrentRater {
+		c.Rater.OnSuccess(now)
+		return
+	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.Rater.OnSuccess(now)
@@ -67,16 +145,46 @@ func (c *Closer) Success(now time.Time, duration time.Duration) {
 func (c *Closer) ErrFailure(now time.Time, duration time.Duration) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.Rater.OnFailure(now)
-	c.lastFailedReserve = now
+	c.onFailure(now, "failure")
 }
 
 // ErrTimeout sends the rater a failure message.
 func (c *Closer) ErrTimeout(now time.Time, duration time.Duration) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.onFailure(now, "timeout")
+}
+
+func (c *Closer) onFailure(now time.Time, cause string) {
+	previousRate := math.NaN()
+	if c.Logger != nil {
+		previousRate = c.rate()
+	}
 	c.Rater.OnFailure(now)
+	c.setLastFailure(now)
+	c.logDecrease(cause, previousRate)
+}
+
+// Observe reports the results of many requests in one call, taking the lock once.  It ends with the same state as
+// calling Success for each success, then ErrFailure and ErrTimeout for each failure and timeout.  This is useful for
+// callers that aggregate results over an interval instead of reporting each request.
+func (c *Closer) Observe(now time.Time, batch aimdcloser.Batch) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	previousRate := math.NaN()
+	if c.Logger != nil {
+		previousRate = c.rate()
+	}
+	aimdcloser.ApplyBatch(c.Rater, now, batch)
+	if batch.Failures+batch.Timeouts > 0 {
+		c.setLastFailure(now)
+		c.logDecrease("batch", previousRate)
+	}
+}
+
+func (c *Closer) setLastFailure(now time.Time) {
 	c.lastFailedReserve = now
+	c.logState.loggedShouldClose = false
 }
 
 // ErrBadRequest is ignored and exists only to satisfy the closer interface.
@@ -95,37 +203,114 @@ func (c *Closer) ErrConcurrencyLimitReject(now time.Time) {
 func (c *Closer) ErrShortCircuit(now time.Time) {
 }
 
-// Closed resets the rater
+// Closed resets the rater.  If the rater is also a circuit.Metrics, it is told the circuit closed instead.
 func (c *Closer) Closed(now time.Time) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.lastFailedReserve = now
+	c.logTransition(now, "circuit closed")
+	c.closedRate = c.rate()
+	c.setLastFailure(now)
+	c.open = false
+	c.dropReservations()
+	if m, ok := c.Rater.(circuit.Metrics); ok {
+		m.Closed(now)
+		return
+	}
 	c.Rater.Reset(now)
 }
 
-// Opened resets the rater
+// Opened resets the rater.  If the rater is also a circuit.Metrics, it is told the circuit opened instead.
 func (c *Closer) Opened(now time.Time) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.lastFailedReserve = now
-	c.Rater.Reset(now)
+	c.setLastFailure(now)
+	c.open = true
+	c.dropReservations()
+	if m, ok := c.Rater.(circuit.Metrics); ok {
+		m.Opened(now)
+	} else {
+		c.Rater.Reset(now)
+	}
+	c.logTransition(now, "circuit opened")
 }
 
 // ShouldClose returns true if the ratecloser has been successful for CloseOnHappyDuration amount of time.
 func (c *Closer) ShouldClose(now time.Time) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return now.Sub(c.lastFailedReserve) > c.CloseOnHappyDuration
+	ret := now.Sub(c.lastFailedReserve) > c.CloseOnHappyDuration
+	c.maybeFlushRejections(now)
+	c.logShouldClose(ret)
+	return ret
 }
 
 // Allow attempts to get a reservation from the rater.  If we are unable to reserve a value, we count this as a failure
-// for the rater.
+// for the rater.  Requests already reserved by AllowBy are allowed without reserving again once their wait is over.
 func (c *Closer) Allow(now time.Time) bool {
+	if c.ConcurrentRater && atomic.LoadInt64(&c.granted) == 0 {
+		if c.Rater.AttemptReserve(now) {
+			atomic.AddInt64(&c.allowed, 1)
+			return true
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.reject(now)
+		return false
+	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if c.useReservation(now) {
+		atomic.AddInt64(&c.allowed, 1)
+		return true
+	}
 	ret := c.Rater.AttemptReserve(now)
 	if !ret {
-		c.lastFailedReserve = now
+		c.reject(now)
+	} else {
+		atomic.AddInt64(&c.allowed, 1)
+	}
+	return ret
+}
+
+// reject records a request Allow did not admit.  It must be called with the lock held.
+func (c *Closer) reject(now time.Time) {
+	c.setLastFailure(now)
+	c.rejected++
+	c.logRejection(now)
+}
+
+// lastClosedRate returns the rate of Rater when the circuit last closed, or NaN if it is unknown.
+func (c *Closer) lastClosedRate() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closedRate == 0 {
+		return math.NaN()
+	}
+	return c.closedRate
+}
+
+// Stats returns the current state of the closer.
+func (c *Closer) Stats(now time.Time) Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ret := Stats{
+		Rate:          math.NaN(),
+		HappyProgress: 1,
+		Allowed:       atomic.LoadInt64(&c.allowed),
+		Rejected:      c.rejected,
+	}
+	if r, ok := c.Rater.(aimdcloser.RateReporter); ok {
+		ret.Rate = r.Rate()
+		ret.Burst = r.MaxBurst()
+	}
+	if c.CloseOnHappyDuration > 0 {
+		ret.HappyProgress = float64(now.Sub(c.lastFailedReserve)) / float64(c.CloseOnHappyDuration)
+	}
+	if ret.HappyProgress < 0 {
+		ret.HappyProgress = 0
+	}
+	if ret.HappyProgress > 1 {
+		ret.HappyProgress = 1
 	}
 	return ret
 }
@@ -1,6 +1,7 @@
 package ratecloser
 
 import (
+	"math"
 	"sync"
 	"testing"
 	"time"
@@ -277,6 +278,58 @@ func TestCloser_ErrTimeout(t *testing.T) {
 	})
 }
 
+func TestCloser_Stats(t *testing.T) {
+	factory := CloserFactory(CloserConfig{
+		RateLimiter:          aimdcloser.AIMDConstructor(1, .5, 10, 2),
+		CloseOnHappyDuration: time.Second * 4,
+	})
+	t.Run("counts", func(t *testing.T) {
+		closer := factory().(*Closer)
+		now := time.Now()
+		closer.Opened(now)
+		for i := 0; i < 3; i++ {
+			closer.Allow(now)
+		}
+		closer.Success(now, time.Millisecond)
+		stats := closer.Stats(now.Add(time.Second))
+		if stats.Allowed != 2 || stats.Rejected != 1 {
+			t.Errorf("unexpected allowed/rejected counts %d/%d", stats.Allowed, stats.Rejected)
+		}
+		if stats.Rate != 11 || stats.Burst != 2 {
+			t.Errorf("unexpected rate/burst %f/%d", stats.Rate, stats.Burst)
+		}
+		if stats.HappyProgress != .25 {
+			t.Errorf("unexpected happy progress %f", stats.HappyProgress)
+		}
+	})
+	t.Run("capped", func(t *testing.T) {
+		closer := factory().(*Closer)
+		now := time.Now()
+		closer.Opened(now)
+		if closer.Stats(now.Add(time.Hour)).HappyProgress != 1 {
+			t.Error("expect happy progress to cap at 1")
+		}
+		if closer.Stats(now.Add(-time.Hour)).HappyProgress != 0 {
+			t.Error("expect happy progress to floor at 0")
+		}
+	})
+	t.Run("unknown_rater", func(t *testing.T) {
+		closer := &Closer{
+			Rater: neverRater{},
+		}
+		if !math.IsNaN(closer.Stats(time.Now()).Rate) {
+			t.Error("expect NaN rate for a rater that cannot report one")
+		}
+	})
+}
+
+type neverRater struct{}
+
+func (neverRater) OnFailure(now time.Time)           {}
+func (neverRater) OnSuccess(now time.Time)           {}
+func (neverRater) AttemptReserve(now time.Time) bool { return false }
+func (neverRater) Reset(now time.Time)               {}
+
 func BenchmarkCloser_Allow_10(b *testing.B) {
 	factory := CloserFactory(CloserConfig{
 		RateLimiter:          aimdcloser.AIMDConstructor(.1, .5, 1/time.Microsecond.Seconds(), 10),
@@ -373,3 +426,21 @@ func BenchmarkCloser_AllowFailureClose_10(b *testing.B) {
 	}
 	wg.Wait()
 }
+
+func TestCloser_slidingWindow(t *testing.T) {
+	c := CloserFactory(CloserConfig{
+		RateLimiter: aimdcloser.SlidingWindowConstructor(1, .5, 2, time.Minute, 6),
+	})().(*Closer)
+	now := time.Now()
+	c.Opened(now)
+	if !c.Allow(now) || !c.Allow(now) {
+		t.Fatal("expected the window's limit to be allowed")
+	}
+	if c.Allow(now.Add(time.Second * 30)) {
+		t.Fatal("expected no more than the window's limit")
+	}
+	c.Success(now, 0)
+	if !c.Allow(now.Add(time.Second * 30)) {
+		t.Fatal("expected a success to raise the limit")
+	}
+}
@@ -0,0 +1,194 @@
+package ratecloser
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+
+	"github.com/cep21/circuit/v3"
+)
+
+// ConcurrencyCloser is a circuit closer that, while the circuit is open, allows as many requests in flight as a
+// ConcurrencyLimiter allows, instead of a rate.  It suits slow endpoints, where how many requests are in flight
+// matters more than how often they start.
+//
+// The circuit does not say which request a result belongs to.  It does report when the request started, since a
+// result's duration ends at the result and starts when Allow was called.  Each result releases the oldest token
+// acquired no later than its request started, and results of requests that started before every token in flight
+// release nothing.  Requests admitted before the circuit opened therefore never release a token they did not
+// take.  Tokens are otherwise interchangeable, so only their latencies are approximate.
+type ConcurrencyCloser struct {
+	// Limiter limits requests in flight while the circuit is open
+	Limiter aimdcloser.ConcurrencyLimiter
+	// CloseOnHappyDuration is how long we should see zero failing or rejected requests before we close the circuit
+	CloseOnHappyDuration time.Duration
+
+	// tokens are in flight, in the order Allow acquired them
+	tokens      []heldToken
+	lastFailure time.Time
+	mu          sync.Mutex
+}
+
+// ConcurrencyCloserConfig configures defaults for ConcurrencyCloser.
+type ConcurrencyCloserConfig struct {
+	// Limiter constructs new concurrency limiters for circuits.  It happens to default to
+	// AIMDConcurrencyConstructor(1, .5, 1) right now, so an open circuit starts with a single request in flight.
+	Limiter func() aimdcloser.ConcurrencyLimiter
+	// CloseOnHappyDuration gives a duration that passing requests cause the circuit to close.  It happens to
+	// default to 10 seconds right now.
+	CloseOnHappyDuration time.Duration
+}
+
+func (o *ConcurrencyCloserConfig) merge(other ConcurrencyCloserConfig) {
+	if o.Limiter == nil {
+		o.Limiter = other.Limiter
+	}
+	if o.CloseOnHappyDuration == 0 {
+		o.CloseOnHappyDuration = other.CloseOnHappyDuration
+	}
+}
+
+var defaultConcurrencyConfig = ConcurrencyCloserConfig{
+	Limiter:              aimdcloser.AIMDConcurrencyConstructor(1, .5, 1),
+	CloseOnHappyDuration: time.Second * 10,
+}
+
+// ConcurrencyCloserFactory is injectable into a circuit's configuration to create concurrency limiting closers
+func ConcurrencyCloserFactory(conf ConcurrencyCloserConfig) func() circuit.OpenToClosed {
+	return func() circuit.OpenToClosed {
+		c := conf
+		c.merge(defaultConcurrencyConfig)
+		return &ConcurrencyCloser{
+			Limiter:              c.Limiter(),
+			CloseOnHappyDuration: c.CloseOnHappyDuration,
+			lastFailure:          time.Now(),
+		}
+	}
+}
+
+// heldToken is a token in flight and when Allow acquired it
+type heldToken struct {
+	at    time.Time
+	token aimdcloser.ConcurrencyToken
+}
+
+// take removes the token at i from the tokens in flight.  It must be called with the lock held.
+func (c *ConcurrencyCloser) take(i int) aimdcloser.ConcurrencyToken {
+	t := c.tokens[i].token
+	copy(c.tokens[i:], c.tokens[i+1:])
+	c.tokens[len(c.tokens)-1] = heldToken{}
+	c.tokens = c.tokens[:len(c.tokens)-1]
+	return t
+}
+
+// release releases the oldest token in flight that was acquired no later than now minus latency, when the request
+// of the result started.  Nothing is released if there is none.  It must be called with the lock held.
+func (c *ConcurrencyCloser) release(now time.Time, outcome aimdcloser.Outcome, latency time.Duration) {
+	started := now.Add(-latency)
+	for i := range c.tokens {
+		if c.tokens[i].at.After(started) {
+			continue
+		}
+		c.take(i).Release(now, outcome, latency)
+		return
+	}
+}
+
+// Success releases a token as a success
+func (c *ConcurrencyCloser) Success(now time.Time, duration time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.release(now, aimdcloser.Success, duration)
+}
+
+// ErrFailure releases a token as a failure
+func (c *ConcurrencyCloser) ErrFailure(now time.Time, duration time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastFailure = now
+	c.release(now, aimdcloser.Failure, duration)
+}
+
+// ErrTimeout releases a token as a failure
+func (c *ConcurrencyCloser) ErrTimeout(now time.Time, duration time.Duration) {
+	c.ErrFailure(now, duration)
+}
+
+// ErrBadRequest releases a token without changing the limit
+func (c *ConcurrencyCloser) ErrBadRequest(now time.Time, duration time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.release(now, aimdcloser.Ignore, duration)
+}
+
+// ErrInterrupt releases a token without changing the limit
+func (c *ConcurrencyCloser) ErrInterrupt(now time.Time, duration time.Duration) {
+	c.ErrBadRequest(now, duration)
+}
+
+// ErrConcurrencyLimitReject releases a token acquired at now without changing the limit, since the circuit rejected
+// the request right after Allow.  The circuit also rejects requests it never asked Allow about, so nothing is
+// released if no token was acquired at now.
+func (c *ConcurrencyCloser) ErrConcurrencyLimitReject(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.tokens {
+		if !c.tokens[i].at.Equal(now) {
+			continue
+		}
+		c.take(i).Release(now, aimdcloser.Ignore, 0)
+		return
+	}
+}
+
+// ErrShortCircuit is ignored and exists only to satisfy the closer interface.
+func (c *ConcurrencyCloser) ErrShortCircuit(now time.Time) {
+}
+
+// Closed forgets every token, since requests are no longer limited
+func (c *ConcurrencyCloser) Closed(now time.Time) {
+	c.reset(now)
+}
+
+// Opened resets the limiter, and starts the happy duration over
+func (c *ConcurrencyCloser) Opened(now time.Time) {
+	c.reset(now)
+}
+
+func (c *ConcurrencyCloser) reset(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastFailure = now
+	c.tokens = nil
+	c.Limiter.Reset(now)
+}
+
+// ShouldClose returns true if there has been no failure or rejection for CloseOnHappyDuration
+func (c *ConcurrencyCloser) ShouldClose(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return now.Sub(c.lastFailure) > c.CloseOnHappyDuration
+}
+
+// Allow acquires a token from the limiter.  A rejected request counts as a failure for ShouldClose.
+func (c *ConcurrencyCloser) Allow(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.Limiter.Acquire(now)
+	if !ok {
+		c.lastFailure = now
+		return false
+	}
+	c.tokens = append(c.tokens, heldToken{at: now, token: t})
+	return true
+}
+
+// InFlight returns how many requests Allow admitted that have not finished
+func (c *ConcurrencyCloser) InFlight() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.tokens)
+}
+
+var _ circuit.OpenToClosed = &ConcurrencyCloser{}
@@ -0,0 +1,150 @@
+package ratecloser
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+
+	"github.com/cep21/circuit/v3"
+)
+
+func TestConcurrencyCloser(t *testing.T) {
+	c := ConcurrencyCloserFactory(ConcurrencyCloserConfig{
+		Limiter:              aimdcloser.AIMDConcurrencyConstructor(2, .5, 2),
+		CloseOnHappyDuration: time.Second,
+	})().(*ConcurrencyCloser)
+	limiter := c.Limiter.(*aimdcloser.AIMDConcurrency)
+	now := time.Now()
+	c.Opened(now)
+	if !c.Allow(now) || !c.Allow(now) {
+		t.Fatal("expected requests under the limit")
+	}
+	if c.Allow(now.Add(time.Second)) {
+		t.Fatal("expected no request past the limit")
+	}
+	if c.ShouldClose(now.Add(time.Millisecond * 1500)) {
+		t.Fatal("expected a rejection to restart the happy duration")
+	}
+	c.Success(now.Add(time.Millisecond), time.Millisecond)
+	if limiter.Limit() != 3 {
+		t.Fatalf("expected a success to raise the limit, got %f", limiter.Limit())
+	}
+	c.ErrInterrupt(now.Add(time.Millisecond), time.Millisecond)
+	if c.InFlight() != 0 || limiter.InFlight() != 0 || limiter.Limit() != 3 {
+		t.Fatal("expected an interrupt to release its token without changing the limit")
+	}
+	// Results without a token in flight release nothing
+	c.Success(now, time.Millisecond)
+	if limiter.Limit() != 3 {
+		t.Fatal("expected no change without a token")
+	}
+	c.Allow(now)
+	c.ErrConcurrencyLimitReject(now)
+	if c.InFlight() != 0 {
+		t.Fatal("expected a concurrency rejection to release its token")
+	}
+	c.Allow(now)
+	c.ErrTimeout(now.Add(time.Second), time.Millisecond)
+	if limiter.Limit() != 1.5 {
+		t.Fatalf("expected a timeout to lower the limit, got %f", limiter.Limit())
+	}
+	if c.ShouldClose(now.Add(time.Millisecond * 1500)) {
+		t.Fatal("expected a failure to restart the happy duration")
+	}
+	if !c.ShouldClose(now.Add(time.Second * 3)) {
+		t.Fatal("expected to close after the happy duration")
+	}
+	c.Allow(now)
+	c.Closed(now)
+	if c.InFlight() != 0 || limiter.Limit() != 2 {
+		t.Fatal("expected closing to reset the limiter")
+	}
+}
+
+func TestConcurrencyCloser_openedWithRequestsInFlight(t *testing.T) {
+	c := ConcurrencyCloserFactory(ConcurrencyCloserConfig{
+		Limiter: aimdcloser.AIMDConcurrencyConstructor(1, .5, 2),
+	})().(*ConcurrencyCloser)
+	limiter := c.Limiter.(*aimdcloser.AIMDConcurrency)
+	now := time.Now()
+	// Requests that started while the circuit was closed are still running when it opens
+	c.Opened(now.Add(time.Second))
+	if !c.Allow(now.Add(time.Second)) || !c.Allow(now.Add(time.Second*2)) {
+		t.Fatal("expected requests under the limit")
+	}
+	// They time out, but release nothing since they started before every token
+	c.ErrTimeout(now.Add(time.Second*3), time.Second*3)
+	c.ErrTimeout(now.Add(time.Second*3), time.Millisecond*2500)
+	if c.InFlight() != 2 || limiter.InFlight() != 2 || limiter.Limit() != 2 {
+		t.Fatal("expected older requests not to release tokens they did not take")
+	}
+	if c.Allow(now.Add(time.Second * 3)) {
+		t.Fatal("expected the limit to hold")
+	}
+	// A rejection that does not follow an Allow releases nothing
+	c.ErrConcurrencyLimitReject(now.Add(time.Millisecond * 3500))
+	if c.InFlight() != 2 {
+		t.Fatal("expected a rejection without a token not to release one")
+	}
+	// A request of the open circuit releases the oldest token, which could have been its own
+	c.Success(now.Add(time.Second*4), time.Second*2)
+	if c.InFlight() != 1 || !c.tokens[0].at.Equal(now.Add(time.Second*2)) {
+		t.Fatal("expected the oldest token to be released")
+	}
+	if limiter.Limit() != 2.5 {
+		t.Fatalf("expected the success to raise the limit, got %f", limiter.Limit())
+	}
+}
+
+func TestConcurrencyCloser_circuit(t *testing.T) {
+	h := circuit.Manager{
+		DefaultCircuitProperties: []circuit.CommandPropertiesConstructor{
+			func(_ string) circuit.Config {
+				return circuit.Config{
+					General: circuit.GeneralConfig{
+						OpenToClosedFactory: ConcurrencyCloserFactory(ConcurrencyCloserConfig{
+							CloseOnHappyDuration: time.Hour,
+						}),
+					},
+				}
+			},
+		},
+	}
+	c := h.MustCreateCircuit("TestConcurrencyCloser_circuit")
+	closer := c.OpenToClose.(*ConcurrencyCloser)
+	c.OpenCircuit()
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- c.Execute(context.Background(), func(ctx context.Context) error {
+			close(started)
+			<-finish
+			return nil
+		}, nil)
+	}()
+	<-started
+	err := c.Execute(context.Background(), func(ctx context.Context) error {
+		return nil
+	}, nil)
+	if err == nil {
+		t.Fatal("expected a second request in flight to be rejected while open")
+	}
+	close(finish)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if closer.InFlight() != 0 {
+		t.Fatal("expected the finished request to release its token")
+	}
+	// The success raised the limit to 2
+	err = c.Execute(context.Background(), func(ctx context.Context) error {
+		return errors.New("bad")
+	}, nil)
+	if err == nil || closer.InFlight() != 0 {
+		t.Fatal("expected a failed request to release its token")
+	}
+}
@@ -0,0 +1,181 @@
+package ratecloser
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+	"golang.org/x/time/rate"
+)
+
+// ErrDeadlineTooClose is returned by Wait when the rate limiter cannot admit a request early enough to finish before
+// its deadline
+var ErrDeadlineTooClose = errors.New("ratecloser: rate limited past the request's deadline")
+
+// reservation is a request AllowBy admitted ahead of time
+type reservation struct {
+	// at is when the wait of the request is over, and Allow may use the reservation
+	at time.Time
+	// held is true while Wait sleeps for the reservation.  Allow does not use held reservations, so the caller of
+	// Wait is the one that uses it.
+	held bool
+	// res gives the request back to the rater, and is nil if the rater cannot give requests back
+	res *rate.Reservation
+}
+
+// reservationGrace is how long after its wait is over a reservation is kept for Allow.  The caller of a reservation
+// that is not used by then is assumed gone, and it is dropped rather than handed to an unrelated request.
+const reservationGrace = time.Millisecond * 100
+
+// AllowBy is a deadline aware companion to Allow, for wrappers that know the deadline of a request before running it
+// through the circuit.  circuit's Allow has no context, so it can only admit or reject a request right now.
+//
+// While the circuit is open, AllowBy reserves a request the rater allows soon enough that, after waiting, at least
+// MinRemaining is left before deadline.  A zero deadline has no limit.  Waits are never longer than MaxWait.  It
+// returns how long the caller should wait before running the request, and false if the request cannot be admitted
+// in time.  Once the wait is over, the next call to Allow uses the reservation, so the request is not charged twice.
+// Until then Allow never uses it, so other requests cannot run early on a reservation that is not theirs.  A
+// reservation Allow has not used within 100ms of being due is dropped, since its caller is gone.
+//
+// Nothing is reserved when the circuit is closed, when MaxWait is zero, or when the rater is neither an
+// aimdcloser.Reserver nor an aimdcloser.DelayReserver.  AllowBy then returns true with no wait, and Allow decides as
+// usual.
+func (c *Closer) AllowBy(now time.Time, deadline time.Time) (time.Duration, bool) {
+	wait, _, ok := c.reserveBy(now, deadline, false)
+	return wait, ok
+}
+
+// reserveBy is AllowBy.  The reservation is nil if nothing was reserved.
+func (c *Closer) reserveBy(now time.Time, deadline time.Time, held bool) (time.Duration, *reservation, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rr, isReserver := c.Rater.(aimdcloser.Reserver)
+	dr, isDelayReserver := c.Rater.(aimdcloser.DelayReserver)
+	if !c.open || c.MaxWait <= 0 || (!isReserver && !isDelayReserver) {
+		return 0, nil, true
+	}
+	budget := c.MaxWait
+	if !deadline.IsZero() {
+		if untilDeadline := deadline.Sub(now) - c.MinRemaining; untilDeadline < budget {
+			budget = untilDeadline
+		}
+	}
+	if budget < 0 {
+		return 0, nil, false
+	}
+	r := &reservation{held: held}
+	var wait time.Duration
+	if isReserver {
+		// A Reserver's reservation can be given back if the caller gives up
+		r.res = rr.ReserveN(now, 1)
+		if !r.res.OK() {
+			return 0, nil, false
+		}
+		if wait = r.res.DelayFrom(now); wait > budget {
+			r.res.CancelAt(now)
+			return 0, nil, false
+		}
+	} else {
+		var ok bool
+		if wait, ok = dr.ReserveWithin(now, budget); !ok {
+			return 0, nil, false
+		}
+	}
+	r.at = now.Add(wait)
+	c.reservations = append(c.reservations, r)
+	atomic.StoreInt64(&c.granted, int64(len(c.reservations)))
+	return wait, r, true
+}
+
+// release lets Allow use r, once the caller of Wait is done sleeping for it
+func (c *Closer) release(r *reservation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r.held = false
+}
+
+// cancel removes r, whose caller gave up, and gives it back to the rater if it can.  Nothing is given back if r was
+// already dropped.
+func (c *Closer) cancel(r *reservation, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, o := range c.reservations {
+		if o != r {
+			continue
+		}
+		c.reservations = append(c.reservations[:i], c.reservations[i+1:]...)
+		atomic.StoreInt64(&c.granted, int64(len(c.reservations)))
+		if r.res != nil {
+			r.res.CancelAt(now)
+		}
+		return
+	}
+}
+
+// useReservation removes a reservation whose wait is over, and returns false if there is none.  Reservations that
+// have been due for longer than reservationGrace are dropped instead of used.  It must be called with the lock held.
+func (c *Closer) useReservation(now time.Time) bool {
+	used := false
+	kept := c.reservations[:0]
+	for _, r := range c.reservations {
+		switch {
+		case r.held || now.Before(r.at):
+			kept = append(kept, r)
+		case now.Sub(r.at) > reservationGrace:
+			// The caller never came, so the request is not handed to somebody else
+		case !used:
+			used = true
+		default:
+			kept = append(kept, r)
+		}
+	}
+	for i := len(kept); i < len(c.reservations); i++ {
+		c.reservations[i] = nil
+	}
+	c.reservations = kept
+	atomic.StoreInt64(&c.granted, int64(len(c.reservations)))
+	return used
+}
+
+// dropReservations forgets every reservation.  It must be called with the lock held.
+func (c *Closer) dropReservations() {
+	c.reservations = nil
+	atomic.StoreInt64(&c.granted, 0)
+}
+
+// Wait calls AllowBy at now with the deadline of ctx, then sleeps for the returned wait.  It returns
+// ErrDeadlineTooClose if the request cannot be admitted before its deadline, or ctx's error if ctx ends while
+// waiting.  A request that stops waiting is given back to the rater if it is an aimdcloser.Reserver.  Run the request
+// through the circuit once Wait returns nil.
+//
+// No other request can use the reservation while Wait sleeps.  circuit's Allow does not say which request is calling,
+// so a request that reaches Allow in the instant between Wait returning and the caller reaching Allow could still
+// use it.
+func (c *Closer) Wait(ctx context.Context, now time.Time) error {
+	deadline, _ := ctx.Deadline()
+	wait, r, ok := c.reserveBy(now, deadline, true)
+	if !ok {
+		return ErrDeadlineTooClose
+	}
+	if r == nil {
+		return nil
+	}
+	if wait <= 0 {
+		c.release(r)
+		return nil
+	}
+	started := time.Now()
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		c.release(r)
+		return nil
+	case <-ctx.Done():
+		// now is the caller's clock, so only move it forward by how long we slept
+		c.cancel(r, now.Add(time.Since(started)))
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,305 @@
+package ratecloser
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+	"github.com/cep21/circuit/v3"
+)
+
+func TestCloser_AllowBy(t *testing.T) {
+	now := time.Now()
+	c := CloserFactory(CloserConfig{
+		RateLimiter:  aimdcloser.AIMDConstructor(1, .5, 10, 1),
+		MaxWait:      time.Second,
+		MinRemaining: time.Millisecond * 50,
+	})().(*Closer)
+	if wait, ok := c.AllowBy(now, now.Add(time.Millisecond)); !ok || wait != 0 {
+		t.Fatal("expected a closed circuit not to wait")
+	}
+	c.Opened(now)
+	if wait, ok := c.AllowBy(now, time.Time{}); !ok || wait != 0 {
+		t.Fatal("expected the burst to be free")
+	}
+	if wait, ok := c.AllowBy(now, now.Add(time.Millisecond*149)); ok {
+		t.Fatalf("expected a 100ms wait not to fit in a 149ms deadline, got %s", wait)
+	}
+	if wait, ok := c.AllowBy(now, now.Add(time.Millisecond*150)); !ok || wait != time.Millisecond*100 {
+		t.Fatalf("expected a 100ms wait to fit in a 150ms deadline, got %s %v", wait, ok)
+	}
+	// Both reservations are used by Allow once they are due, and nothing else is reserved
+	if !c.Allow(now) || !c.Allow(now.Add(time.Millisecond*100)) {
+		t.Fatal("expected Allow to use the reservations")
+	}
+	if c.Allow(now.Add(time.Millisecond * 150)) {
+		t.Fatal("expected Allow to reserve as usual once reservations are used")
+	}
+	if s := c.Stats(now); s.Allowed != 2 || s.Rejected != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	if _, ok := c.AllowBy(now, now.Add(-time.Second)); ok {
+		t.Fatal("expected a past deadline to be refused")
+	}
+	c.AllowBy(now.Add(time.Millisecond*150), time.Time{})
+	c.Closed(now)
+	if c.granted != 0 || len(c.reservations) != 0 {
+		t.Fatal("expected closing to drop reservations")
+	}
+}
+
+func TestCloser_AllowByWithPlainAllow(t *testing.T) {
+	now := time.Now()
+	c := CloserFactory(CloserConfig{
+		RateLimiter: aimdcloser.AIMDConstructor(0, 1, 10, 1),
+		MaxWait:     time.Second,
+	})().(*Closer)
+	c.Opened(now)
+	if !c.Allow(now) {
+		t.Fatal("expected the burst to be free")
+	}
+	wait, ok := c.AllowBy(now, time.Time{})
+	if !ok || wait != time.Millisecond*100 {
+		t.Fatalf("expected to wait for the next token, got %s %v", wait, ok)
+	}
+	// A request that did not reserve cannot run early on the waiter's reservation
+	if c.Allow(now.Add(time.Millisecond * 50)) {
+		t.Fatal("expected a plain Allow not to use a reservation that is not due")
+	}
+	if !c.Allow(now.Add(wait)) {
+		t.Fatal("expected the waiter to be admitted")
+	}
+	if c.Allow(now.Add(wait)) {
+		t.Fatal("expected the rate to hold")
+	}
+
+	// A reservation Wait sleeps for is its own, even once it is due
+	_, r, ok := c.reserveBy(now.Add(wait), time.Time{}, true)
+	if !ok || r == nil {
+		t.Fatal("expected a held reservation")
+	}
+	if c.Allow(now.Add(time.Millisecond * 200)) {
+		t.Fatal("expected a plain Allow not to use a held reservation")
+	}
+	c.release(r)
+	if !c.Allow(now.Add(time.Millisecond * 200)) {
+		t.Fatal("expected the waiter to be admitted")
+	}
+	if s := c.Stats(now); s.Allowed != 3 || s.Rejected != 3 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestCloser_WaitWithPlainAllow(t *testing.T) {
+	c := CloserFactory(CloserConfig{
+		RateLimiter: aimdcloser.AIMDConstructor(0, 1, 10, 1),
+		MaxWait:     time.Second,
+	})().(*Closer)
+	c.Opened(time.Now())
+	if !c.Allow(time.Now()) {
+		t.Fatal("expected the burst to be free")
+	}
+	waited := make(chan bool)
+	go func() {
+		if err := c.Wait(context.Background(), time.Now()); err != nil {
+			t.Error(err)
+		}
+		waited <- c.Allow(time.Now())
+	}()
+	// Plain requests while the waiter sleeps are rejected, rather than taking its reservation
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Millisecond * 10)
+		if c.Allow(time.Now()) {
+			t.Fatal("expected a plain Allow to be rejected while the waiter sleeps")
+		}
+	}
+	if !<-waited {
+		t.Fatal("expected the waiter to be admitted")
+	}
+}
+
+func TestCloser_WaitCanceled(t *testing.T) {
+	now := time.Now()
+	c := CloserFactory(CloserConfig{
+		RateLimiter: aimdcloser.AIMDConstructor(0, 1, 10, 1),
+		MaxWait:     time.Second,
+	})().(*Closer)
+	c.Opened(now)
+	if !c.Allow(now) {
+		t.Fatal("expected the burst to be free")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*10, cancel)
+	if err := c.Wait(ctx, now); err != context.Canceled {
+		t.Fatalf("expected the context's error, got %v", err)
+	}
+	if c.granted != 0 || len(c.reservations) != 0 {
+		t.Fatal("expected the reservation to be removed")
+	}
+	// The token the waiter gave back is there for the next request, and no more
+	if !c.Allow(now.Add(time.Millisecond * 100)) {
+		t.Fatal("expected the reservation to be given back to the rater")
+	}
+	if c.Allow(now.Add(time.Millisecond * 100)) {
+		t.Fatal("expected the rate to hold")
+	}
+}
+
+func TestCloser_AllowByAbandoned(t *testing.T) {
+	now := time.Now()
+	c := CloserFactory(CloserConfig{
+		RateLimiter: aimdcloser.AIMDConstructor(0, 1, 1, 1),
+		MaxWait:     time.Second * 2,
+	})().(*Closer)
+	c.Opened(now)
+	if !c.Allow(now) {
+		t.Fatal("expected the burst to be free")
+	}
+	wait, ok := c.AllowBy(now, time.Time{})
+	if !ok || wait != time.Second {
+		t.Fatalf("expected to wait for the next token, got %s %v", wait, ok)
+	}
+	// The caller of AllowBy never calls Allow, so a later request does not get its reservation
+	if c.Allow(now.Add(wait + reservationGrace + time.Millisecond)) {
+		t.Fatal("expected an abandoned reservation to be dropped")
+	}
+	if c.granted != 0 || len(c.reservations) != 0 {
+		t.Fatal("expected the reservation to be removed")
+	}
+}
+
+func TestCloser_AllowByNeverExceedsDeadline(t *testing.T) {
+	now := time.Now()
+	minRemaining := time.Millisecond * 20
+	c := CloserFactory(CloserConfig{
+		RateLimiter:  aimdcloser.AIMDConstructor(1, .5, 50, 3),
+		MaxWait:      time.Millisecond * 500,
+		MinRemaining: minRemaining,
+	})().(*Closer)
+	c.Opened(now)
+	r := rand.New(rand.NewSource(1))
+	admitted := 0
+	for i := 0; i < 10000; i++ {
+		now = now.Add(time.Duration(r.Int63n(int64(time.Millisecond * 30))))
+		deadline := now.Add(time.Duration(r.Int63n(int64(time.Second))))
+		wait, ok := c.AllowBy(now, deadline)
+		if !ok {
+			continue
+		}
+		admitted++
+		if wait > c.MaxWait {
+			t.Fatalf("wait %s is over MaxWait", wait)
+		}
+		if now.Add(wait).Add(minRemaining).After(deadline) {
+			t.Fatalf("wait %s leaves less than %s before a deadline %s away", wait, minRemaining, deadline.Sub(now))
+		}
+		if !c.Allow(now.Add(wait)) {
+			t.Fatal("expected Allow to admit a reserved request")
+		}
+	}
+	if admitted == 0 {
+		t.Fatal("expected some requests to be admitted")
+	}
+}
+
+func TestCloser_AllowByNotDelayReserver(t *testing.T) {
+	c := CloserFactory(CloserConfig{
+		RateLimiter: func() aimdcloser.RateLimiter {
+			return neverRater{}
+		},
+		MaxWait: time.Second,
+	})().(*Closer)
+	c.Opened(time.Now())
+	if wait, ok := c.AllowBy(time.Now(), time.Time{}); !ok || wait != 0 {
+		t.Fatal("expected limiters that cannot delay to leave the decision to Allow")
+	}
+	if c.Allow(time.Now()) {
+		t.Fatal("expected Allow to decide")
+	}
+}
+
+func TestCloser_Wait(t *testing.T) {
+	h := circuit.Manager{
+		DefaultCircuitProperties: []circuit.CommandPropertiesConstructor{
+			func(_ string) circuit.Config {
+				return circuit.Config{
+					General: circuit.GeneralConfig{
+						OpenToClosedFactory: CloserFactory(CloserConfig{
+							RateLimiter:          aimdcloser.AIMDConstructor(0, 1, 20, 1),
+							CloseOnHappyDuration: time.Hour,
+							MaxWait:              time.Second,
+							MinRemaining:         time.Millisecond * 10,
+						}),
+					},
+				}
+			},
+		},
+	}
+	c := h.MustCreateCircuit("TestCloser_Wait")
+	closer := c.OpenToClose.(*Closer)
+	c.OpenCircuit()
+	run := func(timeout time.Duration) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		deadline, _ := ctx.Deadline()
+		if err := closer.Wait(ctx, time.Now()); err != nil {
+			return err
+		}
+		if time.Now().Add(time.Millisecond * 10).After(deadline) {
+			t.Fatal("Wait left less than MinRemaining before the deadline")
+		}
+		return c.Execute(ctx, func(ctx context.Context) error {
+			return nil
+		}, nil)
+	}
+	if err := run(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// The next token is 50ms away
+	if err := run(time.Millisecond * 30); err != ErrDeadlineTooClose {
+		t.Fatalf("expected the deadline to be too close, got %v", err)
+	}
+	start := time.Now()
+	if err := run(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < time.Millisecond*30 {
+		t.Fatal("expected to wait for the next token")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := closer.Wait(ctx, time.Now()); err != context.Canceled {
+		t.Fatalf("expected a canceled context to stop waiting, got %v", err)
+	}
+}
+
+func TestCloser_coDel(t *testing.T) {
+	c := CloserFactory(CloserConfig{
+		RateLimiter: aimdcloser.CoDelConstructor(0, 1, 100, 1, time.Millisecond*5, time.Millisecond*100),
+		MaxWait:     time.Second,
+	})().(*Closer)
+	codel := c.Rater.(*aimdcloser.CoDel)
+	now := time.Unix(1000, 0)
+	c.Opened(now)
+	// Requests arrive twice as fast as the rate, and queue through AllowBy
+	var total time.Duration
+	admitted := 0
+	for i := 0; i < 2000; i++ {
+		now = now.Add(time.Millisecond * 5)
+		if wait, ok := c.AllowBy(now, time.Time{}); ok {
+			total += wait
+			admitted++
+			if !c.Allow(now.Add(wait)) {
+				t.Fatal("expected Allow to use the reservation")
+			}
+		}
+	}
+	if codel.Drops() == 0 {
+		t.Fatal("expected CoDel to drop requests")
+	}
+	if mean := total / time.Duration(admitted); mean > c.MaxWait/2 {
+		t.Fatalf("expected CoDel to keep the queue short, waited %s on average", mean)
+	}
+}
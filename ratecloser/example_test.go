@@ -3,6 +3,7 @@ package ratecloser_test
 import (
 	"time"
 
+	"github.com/cep21/aimdcloser"
 	"github.com/cep21/aimdcloser/ratecloser"
 	"github.com/cep21/circuit/v3"
 )
@@ -28,3 +29,23 @@ func ExampleCloserFactory() {
 	_ = c.OpenToClose.(*ratecloser.Closer)
 	// Output:
 }
+
+func ExampleCloserConfig_errorRate() {
+	// Only back off while more than 5% of recent requests fail, instead of on every failure
+	m := circuit.Manager{
+		DefaultCircuitProperties: []circuit.CommandPropertiesConstructor{
+			func(_ string) circuit.Config {
+				return circuit.Config{
+					General: circuit.GeneralConfig{
+						OpenToClosedFactory: ratecloser.CloserFactory(ratecloser.CloserConfig{
+							RateLimiter: aimdcloser.ErrorRateAIMDConstructor(1, .5, 100, 10, time.Second*5, .05),
+						}),
+					},
+				}
+			},
+		},
+	}
+	c := m.MustCreateCircuit("example_circuit")
+	_ = c.OpenToClose.(*ratecloser.Closer).Rater.(*aimdcloser.ErrorRateAIMD)
+	// Output:
+}
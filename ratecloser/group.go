@@ -0,0 +1,156 @@
+package ratecloser
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+
+	"github.com/cep21/circuit/v3"
+)
+
+// RateGroup shares rate limiters between the closers of many circuits, so circuits that point at the same backend
+// probe it with one combined rate instead of one rate each.  Circuits are grouped by Key.
+//
+// The shared limiter is reset only when the first circuit of a group opens.  Circuits that open while others in
+// their group are already open join at the group's learned rate, and circuits that close leave it untouched.
+type RateGroup struct {
+	// RateLimiter constructs the limiter of each group.  Defaults to the default of CloserConfig.RateLimiter.
+	RateLimiter func() aimdcloser.RateLimiter
+	// Key returns the group of a circuit.  Defaults to putting every circuit in the same group.
+	Key func(circuitName string) string
+
+	groups map[string]*sharedRater
+	mu     sync.Mutex
+}
+
+// Member returns a constructor for the circuit named circuitName that is usable as CloserConfig.RateLimiter.
+func (g *RateGroup) Member(circuitName string) func() aimdcloser.RateLimiter {
+	key := ""
+	if g.Key != nil {
+		key = g.Key(circuitName)
+	}
+	return func() aimdcloser.RateLimiter {
+		return &GroupMember{
+			shared: g.group(key),
+		}
+	}
+}
+
+func (g *RateGroup) group(key string) *sharedRater {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.groups == nil {
+		g.groups = make(map[string]*sharedRater)
+	}
+	if ret, exists := g.groups[key]; exists {
+		return ret
+	}
+	constructor := g.RateLimiter
+	if constructor == nil {
+		constructor = defaultConfig.RateLimiter
+	}
+	ret := &sharedRater{
+		rater: constructor(),
+	}
+	g.groups[key] = ret
+	return ret
+}
+
+// sharedRater is the limiter of a single group
+type sharedRater struct {
+	rater     aimdcloser.RateLimiter
+	openCount int
+	mu        sync.Mutex
+}
+
+// GroupMember is the RateLimiter a single closer uses to take part in a RateGroup.  It is safe for concurrent use.
+type GroupMember struct {
+	shared *sharedRater
+	// open is protected by shared.mu
+	open bool
+}
+
+// OnFailure lowers the rate of the whole group
+func (m *GroupMember) OnFailure(now time.Time) {
+	m.shared.mu.Lock()
+	defer m.shared.mu.Unlock()
+	m.shared.rater.OnFailure(now)
+}
+
+// OnSuccess raises the rate of the whole group
+func (m *GroupMember) OnSuccess(now time.Time) {
+	m.shared.mu.Lock()
+	defer m.shared.mu.Unlock()
+	m.shared.rater.OnSuccess(now)
+}
+
+// AttemptReserve reserves a request from the group's limiter
+func (m *GroupMember) AttemptReserve(now time.Time) bool {
+	m.shared.mu.Lock()
+	defer m.shared.mu.Unlock()
+	return m.shared.rater.AttemptReserve(now)
+}
+
+// Reset resets the group's limiter, unless another member of the group is open and still using it.
+func (m *GroupMember) Reset(now time.Time) {
+	m.shared.mu.Lock()
+	defer m.shared.mu.Unlock()
+	othersOpen := m.shared.openCount
+	if m.open {
+		othersOpen--
+	}
+	if othersOpen == 0 {
+		m.shared.rater.Reset(now)
+	}
+}
+
+// Opened resets the group's limiter if this is the first open circuit of the group.
+func (m *GroupMember) Opened(now time.Time) {
+	m.shared.mu.Lock()
+	defer m.shared.mu.Unlock()
+	if m.open {
+		return
+	}
+	m.open = true
+	m.shared.openCount++
+	if m.shared.openCount == 1 {
+		m.shared.rater.Reset(now)
+	}
+}
+
+// Closed removes this circuit from the group's open circuits.  It does not change the group's limiter.
+func (m *GroupMember) Closed(now time.Time) {
+	m.shared.mu.Lock()
+	defer m.shared.mu.Unlock()
+	if !m.open {
+		return
+	}
+	m.open = false
+	m.shared.openCount--
+}
+
+// Rate returns the group's rate, or NaN if the group's limiter is not an aimdcloser.RateReporter
+func (m *GroupMember) Rate() float64 {
+	m.shared.mu.Lock()
+	defer m.shared.mu.Unlock()
+	if r, ok := m.shared.rater.(aimdcloser.RateReporter); ok {
+		return r.Rate()
+	}
+	return math.NaN()
+}
+
+// MaxBurst returns the group's burst, or zero if the group's limiter is not an aimdcloser.RateReporter
+func (m *GroupMember) MaxBurst() int {
+	m.shared.mu.Lock()
+	defer m.shared.mu.Unlock()
+	if r, ok := m.shared.rater.(aimdcloser.RateReporter); ok {
+		return r.MaxBurst()
+	}
+	return 0
+}
+
+var _ aimdcloser.RateLimiter = &GroupMember{}
+var _ aimdcloser.RateReporter = &GroupMember{}
+var _ circuit.Metrics = &GroupMember{}
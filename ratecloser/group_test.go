@@ -0,0 +1,166 @@
+package ratecloser
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+	"github.com/cep21/circuit/v3"
+)
+
+func groupClosers(g *RateGroup, names ...string) []*Closer {
+	ret := make([]*Closer, 0, len(names))
+	for _, name := range names {
+		ret = append(ret, CloserFactory(CloserConfig{
+			RateLimiter: g.Member(name),
+		})().(*Closer))
+	}
+	return ret
+}
+
+func TestRateGroup_shares(t *testing.T) {
+	g := &RateGroup{
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 1, 4),
+	}
+	closers := groupClosers(g, "a", "b")
+	now := time.Now()
+	closers[0].Opened(now)
+	closers[1].Opened(now)
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		for _, c := range closers {
+			if c.Allow(now) {
+				allowed++
+			}
+		}
+	}
+	if allowed != 4 {
+		t.Errorf("expect both closers to share one burst of 4, got %d", allowed)
+	}
+	closers[0].ErrFailure(now, time.Millisecond)
+	if closers[1].Rater.(*GroupMember).Rate() != .5 {
+		t.Error("expect a failure of one member to lower the rate of the other")
+	}
+}
+
+func TestRateGroup_Key(t *testing.T) {
+	g := &RateGroup{
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 1, 1),
+		Key: func(circuitName string) string {
+			return strings.SplitN(circuitName, ".", 2)[0]
+		},
+	}
+	closers := groupClosers(g, "hosta.get", "hosta.put", "hostb.get")
+	now := time.Now()
+	for _, c := range closers {
+		c.Opened(now)
+	}
+	if !closers[0].Allow(now) {
+		t.Error("expect first request of hosta to be allowed")
+	}
+	if closers[1].Allow(now) {
+		t.Error("expect hosta's burst to be shared")
+	}
+	if !closers[2].Allow(now) {
+		t.Error("expect hostb to have its own limiter")
+	}
+}
+
+func TestRateGroup_Reset(t *testing.T) {
+	g := &RateGroup{
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 8, 1),
+	}
+	closers := groupClosers(g, "a", "b")
+	rate := func() float64 {
+		return closers[0].Rater.(*GroupMember).Rate()
+	}
+	now := time.Now()
+	closers[0].Opened(now)
+	closers[0].ErrFailure(now, time.Millisecond)
+	if rate() != 4 {
+		t.Fatal("expect failure to lower the rate")
+	}
+	closers[1].Opened(now)
+	if rate() != 4 {
+		t.Error("expect a second member opening to join at the learned rate")
+	}
+	closers[1].ErrFailure(now, time.Millisecond)
+	closers[1].Closed(now)
+	if rate() != 2 {
+		t.Error("expect a member closing to leave the learned rate alone")
+	}
+	closers[1].Rater.Reset(now)
+	if rate() != 2 {
+		t.Error("expect no direct reset while another member is open")
+	}
+	closers[0].Closed(now)
+	closers[1].Opened(now)
+	if rate() != 8 {
+		t.Error("expect the first member to open to reset the group")
+	}
+	closers[1].Opened(now)
+	closers[1].ErrFailure(now, time.Millisecond)
+	closers[1].Rater.Reset(now)
+	if rate() != 8 {
+		t.Error("expect a direct reset when no other member is open")
+	}
+}
+
+func TestRateGroup_default(t *testing.T) {
+	g := &RateGroup{}
+	c := groupClosers(g, "a")[0]
+	if c.Rater.(*GroupMember).Rate() != 1/time.Microsecond.Seconds() {
+		t.Error("expect the default closer rate limiter")
+	}
+	if c.Rater.(*GroupMember).MaxBurst() != 10 {
+		t.Error("expect the default closer burst")
+	}
+}
+
+func TestRateGroup_Manager(t *testing.T) {
+	g := &RateGroup{}
+	m := circuit.Manager{
+		DefaultCircuitProperties: []circuit.CommandPropertiesConstructor{
+			func(circuitName string) circuit.Config {
+				return circuit.Config{
+					General: circuit.GeneralConfig{
+						OpenToClosedFactory: CloserFactory(CloserConfig{
+							RateLimiter: g.Member(circuitName),
+						}),
+					},
+				}
+			},
+		},
+	}
+	a := m.MustCreateCircuit("a").OpenToClose.(*Closer)
+	b := m.MustCreateCircuit("b").OpenToClose.(*Closer)
+	if a.Rater.(*GroupMember).shared != b.Rater.(*GroupMember).shared {
+		t.Error("expect circuits of a manager to share a group")
+	}
+}
+
+func TestRateGroup_concurrent(t *testing.T) {
+	g := &RateGroup{}
+	closers := groupClosers(g, "a", "b", "c", "d")
+	wg := sync.WaitGroup{}
+	for _, c := range closers {
+		wg.Add(1)
+		go func(c *Closer) {
+			defer wg.Done()
+			now := time.Now()
+			for i := 0; i < 100; i++ {
+				c.Opened(now)
+				c.Allow(now)
+				c.Success(now, time.Millisecond)
+				c.ErrFailure(now, time.Millisecond)
+				c.Closed(now)
+			}
+		}(c)
+	}
+	wg.Wait()
+	if g.groups[""].openCount != 0 {
+		t.Error("expect every member to be closed")
+	}
+}
@@ -0,0 +1,87 @@
+package ratecloser
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+)
+
+// Logger records the decisions a Closer makes.  It is a subset of *slog.Logger from log/slog, so a *slog.Logger
+// can be used directly.
+type Logger interface {
+	// Debug is used for frequent, low level events like individual rate decreases
+	Debug(msg string, args ...interface{})
+	// Info is used for state transitions and periodic summaries
+	Info(msg string, args ...interface{})
+}
+
+// logState tracks what a Closer has already logged, so noisy events can be coalesced.  It is protected by the
+// Closer's mutex.
+type logState struct {
+	rejectedSinceLog  int64
+	lastRejectionLog  time.Time
+	loggedShouldClose bool
+}
+
+func (c *Closer) rate() float64 {
+	if r, ok := c.Rater.(aimdcloser.RateReporter); ok {
+		return r.Rate()
+	}
+	return math.NaN()
+}
+
+func (c *Closer) logTransition(now time.Time, msg string) {
+	if c.Logger == nil {
+		return
+	}
+	c.flushRejections(now)
+	c.Logger.Info(msg, "rate", c.rate(), "allowed", atomic.LoadInt64(&c.allowed), "rejected", c.rejected)
+}
+
+func (c *Closer) logDecrease(cause string, previousRate float64) {
+	if c.Logger == nil {
+		return
+	}
+	c.Logger.Debug("rate decreased", "cause", cause, "from", previousRate, "to", c.rate())
+}
+
+func (c *Closer) logRejection(now time.Time) {
+	if c.Logger == nil {
+		return
+	}
+	if c.logState.lastRejectionLog.IsZero() {
+		c.logState.lastRejectionLog = now
+	}
+	c.logState.rejectedSinceLog++
+	c.maybeFlushRejections(now)
+}
+
+// maybeFlushRejections writes a summary of rejections once RejectionLogInterval has passed since the last one.  It is
+// also called from ShouldClose, so rejections before a quiet period are not held until the next rejection.
+func (c *Closer) maybeFlushRejections(now time.Time) {
+	if c.Logger == nil || c.logState.rejectedSinceLog == 0 {
+		return
+	}
+	if now.Sub(c.logState.lastRejectionLog) >= c.RejectionLogInterval {
+		c.flushRejections(now)
+	}
+}
+
+// flushRejections writes a summary of rejections since the last summary, if there were any
+func (c *Closer) flushRejections(now time.Time) {
+	if c.logState.rejectedSinceLog > 0 {
+		c.Logger.Info("requests rejected", "count", c.logState.rejectedSinceLog, "since", c.logState.lastRejectionLog, "rate", c.rate())
+	}
+	c.logState.rejectedSinceLog = 0
+	c.logState.lastRejectionLog = now
+}
+
+func (c *Closer) logShouldClose(shouldClose bool) {
+	if c.Logger == nil || !shouldClose || c.logState.loggedShouldClose {
+		return
+	}
+	c.logState.loggedShouldClose = true
+	c.Logger.Info("closer ready to close", "happy_duration", c.CloseOnHappyDuration, "last_failure", c.lastFailedReserve, "rate", c.rate())
+}
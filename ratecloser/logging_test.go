@@ -0,0 +1,196 @@
+package ratecloser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+)
+
+type logLine struct {
+	level string
+	msg   string
+	args  []interface{}
+}
+
+type recordingLogger struct {
+	lines []logLine
+}
+
+func (r *recordingLogger) Debug(msg string, args ...interface{}) {
+	r.lines = append(r.lines, logLine{level: "debug", msg: msg, args: args})
+}
+
+func (r *recordingLogger) Info(msg string, args ...interface{}) {
+	r.lines = append(r.lines, logLine{level: "info", msg: msg, args: args})
+}
+
+func (r *recordingLogger) count(msg string) int {
+	ret := 0
+	for _, l := range r.lines {
+		if l.msg == msg {
+			ret++
+		}
+	}
+	return ret
+}
+
+func (r *recordingLogger) last(msg string) *logLine {
+	for i := len(r.lines) - 1; i >= 0; i-- {
+		if r.lines[i].msg == msg {
+			return &r.lines[i]
+		}
+	}
+	return nil
+}
+
+// arg returns the value following key in a slog style key/value list
+func (l *logLine) arg(key string) interface{} {
+	for i := 0; i+1 < len(l.args); i += 2 {
+		if l.args[i] == key {
+			return l.args[i+1]
+		}
+	}
+	return nil
+}
+
+func loggingCloser(logger Logger) *Closer {
+	return CloserFactory(CloserConfig{
+		RateLimiter:          aimdcloser.AIMDConstructor(1, .5, 10, 2),
+		CloseOnHappyDuration: time.Second * 5,
+		Logger:               logger,
+		RejectionLogInterval: time.Second,
+	})().(*Closer)
+}
+
+func TestCloser_Logger(t *testing.T) {
+	t.Run("transitions", func(t *testing.T) {
+		l := &recordingLogger{}
+		closer := loggingCloser(l)
+		now := time.Now()
+		closer.Opened(now)
+		closer.Closed(now.Add(time.Minute))
+		if l.count("circuit opened") != 1 || l.count("circuit closed") != 1 {
+			t.Error("expected to log open and close transitions")
+		}
+		if l.last("circuit opened").level != "info" {
+			t.Error("expected transitions at info level")
+		}
+	})
+	t.Run("decrease", func(t *testing.T) {
+		l := &recordingLogger{}
+		closer := loggingCloser(l)
+		now := time.Now()
+		closer.Opened(now)
+		closer.ErrFailure(now, time.Millisecond)
+		closer.ErrTimeout(now, time.Millisecond)
+		if l.count("rate decreased") != 2 {
+			t.Fatal("expected every decrease to be logged")
+		}
+		line := l.last("rate decreased")
+		if line.arg("cause") != "timeout" {
+			t.Errorf("unexpected cause %v", line.arg("cause"))
+		}
+		if line.arg("from") != 5.0 || line.arg("to") != 2.5 {
+			t.Errorf("unexpected from/to %v/%v", line.arg("from"), line.arg("to"))
+		}
+	})
+	t.Run("rejections_coalesce", func(t *testing.T) {
+		l := &recordingLogger{}
+		closer := loggingCloser(l)
+		now := time.Now()
+		closer.Opened(now)
+		for i := 0; i < 100; i++ {
+			closer.Allow(now)
+		}
+		if l.count("requests rejected") != 0 {
+			t.Error("expected rejections to wait for the interval")
+		}
+		now = now.Add(time.Second)
+		// The burst refills, so the third request is a rejection
+		for i := 0; i < 3; i++ {
+			closer.Allow(now)
+		}
+		if l.count("requests rejected") != 1 {
+			t.Fatal("expected one rejection summary")
+		}
+		if l.last("requests rejected").arg("count") != int64(99) {
+			t.Errorf("unexpected rejection count %v", l.last("requests rejected").arg("count"))
+		}
+	})
+	t.Run("rejections_flush_on_close", func(t *testing.T) {
+		l := &recordingLogger{}
+		closer := loggingCloser(l)
+		now := time.Now()
+		closer.Opened(now)
+		for i := 0; i < 3; i++ {
+			closer.Allow(now)
+		}
+		closer.Closed(now)
+		if l.last("requests rejected").arg("count") != int64(1) {
+			t.Error("expected pending rejections to be summarized when the circuit closes")
+		}
+	})
+	t.Run("rejections_since", func(t *testing.T) {
+		l := &recordingLogger{}
+		closer := loggingCloser(l)
+		now := time.Now()
+		for i := 0; i < 3; i++ {
+			closer.Allow(now)
+		}
+		for i := 0; i < 3; i++ {
+			closer.Allow(now.Add(time.Second * 2))
+		}
+		line := l.last("requests rejected")
+		if line == nil || !line.arg("since").(time.Time).Equal(now) {
+			t.Fatal("expected the first summary to be since the first rejection")
+		}
+	})
+	t.Run("rejections_flush_when_quiet", func(t *testing.T) {
+		l := &recordingLogger{}
+		closer := loggingCloser(l)
+		now := time.Now()
+		closer.Opened(now)
+		for i := 0; i < 3; i++ {
+			closer.Allow(now)
+		}
+		closer.ShouldClose(now.Add(time.Millisecond))
+		if l.count("requests rejected") != 0 {
+			t.Error("expected rejections to wait for the interval")
+		}
+		closer.ShouldClose(now.Add(time.Second))
+		if l.count("requests rejected") != 1 || l.last("requests rejected").arg("count") != int64(1) {
+			t.Error("expected ShouldClose to summarize rejections once the interval passed")
+		}
+	})
+	t.Run("should_close_once", func(t *testing.T) {
+		l := &recordingLogger{}
+		closer := loggingCloser(l)
+		now := time.Now()
+		closer.Opened(now)
+		closer.ShouldClose(now)
+		if l.count("closer ready to close") != 0 {
+			t.Error("expected no log before ShouldClose is true")
+		}
+		now = now.Add(time.Second * 6)
+		closer.ShouldClose(now)
+		closer.ShouldClose(now)
+		if l.count("closer ready to close") != 1 {
+			t.Error("expected to log once when ShouldClose becomes true")
+		}
+		closer.ErrFailure(now, time.Millisecond)
+		closer.ShouldClose(now.Add(time.Second * 6))
+		if l.count("closer ready to close") != 2 {
+			t.Error("expected to log again after a failure")
+		}
+	})
+	t.Run("nil", func(t *testing.T) {
+		closer := loggingCloser(nil)
+		now := time.Now()
+		closer.Opened(now)
+		closer.Allow(now)
+		closer.ErrFailure(now, time.Millisecond)
+		closer.ShouldClose(now)
+		closer.Closed(now)
+	})
+}
@@ -0,0 +1,298 @@
+package ratecloser
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+
+	"github.com/cep21/circuit/v3"
+)
+
+// RampUp is a circuit opener that keeps rate limiting a circuit for a while after it closes, so a backend that just
+// recovered is not instantly hit with unrestricted traffic.  The ramp starts at the rate Closer had when the circuit
+// closed and grows with AIMD.  Requests over the ramp's rate are shed with Prevent.  Once Duration passes, RampUp
+// gets out of the way.  Whether the circuit should open is always decided by Opener.
+type RampUp struct {
+	// Opener decides if the circuit should open and can also prevent requests
+	Opener circuit.ClosedToOpen
+	// Closer is the closer of the same circuit.  If nil, or if it has no known rate, ramps start at InitialRate.
+	Closer *Closer
+	// InitialRate is the requests / sec the ramp starts at if Closer cannot provide one.  Zero turns off the ramp
+	// in that case.
+	InitialRate float64
+	// Duration is how long after closing we keep limiting requests
+	Duration time.Duration
+	// AdditiveIncrease is how many requests / sec are added to the ramp on a success
+	AdditiveIncrease float64
+	// MultiplicativeDecrease is how the ramp's rate is multiplied on a failure
+	MultiplicativeDecrease float64
+	// Burst limits how many requests may happen at once during the ramp
+	Burst int
+
+	// ramp is the limiter of the current ramp, or nil when no ramp is active
+	ramp      *aimdcloser.AIMD
+	rampUntil time.Time
+	mu        sync.Mutex
+}
+
+// RampUpConfig configures defaults for RampUp.
+type RampUpConfig struct {
+	// Opener constructs the opener the ramp delegates to.  We default to never opening the circuit.
+	Opener func() circuit.ClosedToOpen
+	// InitialRate is used if the ramp has no closer's rate to start from.  We default to no ramp in that case.
+	InitialRate float64
+	// Duration is how long the ramp lasts.  It happens to be 30 seconds right now.
+	Duration time.Duration
+	// AdditiveIncrease is how many requests / sec a success adds.  It happens to be 1 right now.
+	AdditiveIncrease float64
+	// MultiplicativeDecrease multiplies the rate on a failure.  It happens to be .5 right now.
+	MultiplicativeDecrease float64
+	// Burst limits requests at once during the ramp.  It happens to be 10 right now.
+	Burst int
+}
+
+func (o *RampUpConfig) merge(other RampUpConfig) {
+	if o.Opener == nil {
+		o.Opener = other.Opener
+	}
+	if o.InitialRate == 0 {
+		o.InitialRate = other.InitialRate
+	}
+	if o.Duration == 0 {
+		o.Duration = other.Duration
+	}
+	if o.AdditiveIncrease == 0 {
+		o.AdditiveIncrease = other.AdditiveIncrease
+	}
+	if o.MultiplicativeDecrease == 0 {
+		o.MultiplicativeDecrease = other.MultiplicativeDecrease
+	}
+	if o.Burst == 0 {
+		o.Burst = other.Burst
+	}
+}
+
+var defaultRampUpConfig = RampUpConfig{
+	Opener:                 neverOpensFactory,
+	Duration:               time.Second * 30,
+	AdditiveIncrease:       1,
+	MultiplicativeDecrease: .5,
+	Burst:                  10,
+}
+
+// RampUpFactory creates ramps that are not linked to a Closer, so they always start at InitialRate.
+func RampUpFactory(conf RampUpConfig) func() circuit.ClosedToOpen {
+	return func() circuit.ClosedToOpen {
+		return newRampUp(conf)
+	}
+}
+
+func newRampUp(conf RampUpConfig) *RampUp {
+	c := conf
+	c.merge(defaultRampUpConfig)
+	return &RampUp{
+		Opener:                 c.Opener(),
+		InitialRate:            c.InitialRate,
+		Duration:               c.Duration,
+		AdditiveIncrease:       c.AdditiveIncrease,
+		MultiplicativeDecrease: c.MultiplicativeDecrease,
+		Burst:                  c.Burst,
+	}
+}
+
+// RampUpCircuitFactory creates circuit configurations whose Closer and RampUp are linked, so ramps start at the
+// rate the Closer ended at.  Use Configure as a circuit.CommandPropertiesConstructor.
+type RampUpCircuitFactory struct {
+	CloserConfig CloserConfig
+	RampUpConfig RampUpConfig
+}
+
+// Configure creates a circuit configuration for a single circuit.  The returned factories must only be used for
+// the circuit named circuitName.
+func (f *RampUpCircuitFactory) Configure(circuitName string) circuit.Config {
+	closerFactory := CloserFactory(f.CloserConfig)
+	rampConfig := f.RampUpConfig
+	// The circuit creates its OpenToClosed before its ClosedToOpen, but we do not depend on that: the closer is only
+	// read when the circuit closes.
+	var mu sync.Mutex
+	var lastCloser *Closer
+	return circuit.Config{
+		General: circuit.GeneralConfig{
+			OpenToClosedFactory: func() circuit.OpenToClosed {
+				c := closerFactory().(*Closer)
+				mu.Lock()
+				defer mu.Unlock()
+				lastCloser = c
+				return c
+			},
+			ClosedToOpenFactory: func() circuit.ClosedToOpen {
+				return &linkedRampUp{
+					RampUp: newRampUp(rampConfig),
+					closer: func() *Closer {
+						mu.Lock()
+						defer mu.Unlock()
+						return lastCloser
+					},
+				}
+			},
+		},
+	}
+}
+
+// linkedRampUp finds its closer when the circuit closes, since the closer may not exist when the ramp is created.
+type linkedRampUp struct {
+	*RampUp
+	closer func() *Closer
+}
+
+func (l *linkedRampUp) Closed(now time.Time) {
+	l.RampUp.mu.Lock()
+	l.RampUp.Closer = l.closer()
+	l.RampUp.mu.Unlock()
+	l.RampUp.Closed(now)
+}
+
+func (r *RampUp) startRate() float64 {
+	if r.Closer != nil {
+		if rate := r.Closer.lastClosedRate(); !math.IsNaN(rate) {
+			return rate
+		}
+	}
+	return r.InitialRate
+}
+
+// activeRamp returns the current ramp, ending it if Duration has passed.  It must be called with the mutex held.
+func (r *RampUp) activeRamp(now time.Time) *aimdcloser.AIMD {
+	if r.ramp != nil && !now.Before(r.rampUntil) {
+		r.ramp = nil
+	}
+	return r.ramp
+}
+
+// Closed starts a ramp
+func (r *RampUp) Closed(now time.Time) {
+	r.Opener.Closed(now)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ramp = nil
+	startRate := r.startRate()
+	if startRate <= 0 || math.IsInf(startRate, 1) || r.Duration <= 0 {
+		return
+	}
+	r.ramp = &aimdcloser.AIMD{
+		AdditiveIncrease:       r.AdditiveIncrease,
+		MultiplicativeDecrease: r.MultiplicativeDecrease,
+		InitialRate:            startRate,
+		Burst:                  r.Burst,
+	}
+	r.ramp.Reset(now)
+	r.rampUntil = now.Add(r.Duration)
+}
+
+// Opened ends any ramp in progress
+func (r *RampUp) Opened(now time.Time) {
+	r.Opener.Opened(now)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ramp = nil
+}
+
+// Prevent returns true if Opener prevents the request, or if a ramp is active and over its rate.
+func (r *RampUp) Prevent(now time.Time) bool {
+	if r.Opener.Prevent(now) {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ramp := r.activeRamp(now); ramp != nil {
+		return !ramp.AttemptReserve(now)
+	}
+	return false
+}
+
+// ShouldOpen returns if Opener should open
+func (r *RampUp) ShouldOpen(now time.Time) bool {
+	return r.Opener.ShouldOpen(now)
+}
+
+// Ramping returns true if a ramp is currently limiting requests.
+func (r *RampUp) Ramping(now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.activeRamp(now) != nil
+}
+
+// Success increases the ramp's rate
+func (r *RampUp) Success(now time.Time, duration time.Duration) {
+	r.Opener.Success(now, duration)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ramp := r.activeRamp(now); ramp != nil {
+		ramp.OnSuccess(now)
+	}
+}
+
+// ErrFailure decreases the ramp's rate
+func (r *RampUp) ErrFailure(now time.Time, duration time.Duration) {
+	r.Opener.ErrFailure(now, duration)
+	r.onFailure(now)
+}
+
+// ErrTimeout decreases the ramp's rate
+func (r *RampUp) ErrTimeout(now time.Time, duration time.Duration) {
+	r.Opener.ErrTimeout(now, duration)
+	r.onFailure(now)
+}
+
+func (r *RampUp) onFailure(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ramp := r.activeRamp(now); ramp != nil {
+		ramp.OnFailure(now)
+	}
+}
+
+// ErrBadRequest is sent to Opener
+func (r *RampUp) ErrBadRequest(now time.Time, duration time.Duration) {
+	r.Opener.ErrBadRequest(now, duration)
+}
+
+// ErrInterrupt is sent to Opener
+func (r *RampUp) ErrInterrupt(now time.Time, duration time.Duration) {
+	r.Opener.ErrInterrupt(now, duration)
+}
+
+// ErrConcurrencyLimitReject is sent to Opener
+func (r *RampUp) ErrConcurrencyLimitReject(now time.Time) {
+	r.Opener.ErrConcurrencyLimitReject(now)
+}
+
+// ErrShortCircuit is sent to Opener
+func (r *RampUp) ErrShortCircuit(now time.Time) {
+	r.Opener.ErrShortCircuit(now)
+}
+
+func neverOpensFactory() circuit.ClosedToOpen {
+	return neverOpens{}
+}
+
+// neverOpens is the default opener of circuit, which is not exported there
+type neverOpens struct{}
+
+func (neverOpens) Prevent(now time.Time) bool                          { return false }
+func (neverOpens) ShouldOpen(now time.Time) bool                       { return false }
+func (neverOpens) Success(now time.Time, duration time.Duration)       {}
+func (neverOpens) ErrFailure(now time.Time, duration time.Duration)    {}
+func (neverOpens) ErrTimeout(now time.Time, duration time.Duration)    {}
+func (neverOpens) ErrBadRequest(now time.Time, duration time.Duration) {}
+func (neverOpens) ErrInterrupt(now time.Time, duration time.Duration)  {}
+func (neverOpens) ErrConcurrencyLimitReject(now time.Time)             {}
+func (neverOpens) ErrShortCircuit(now time.Time)                       {}
+func (neverOpens) Opened(now time.Time)                                {}
+func (neverOpens) Closed(now time.Time)                                {}
+
+// Type check we are implementing the correct types for our ramp
+var _ circuit.ClosedToOpen = &RampUp{}
+var _ circuit.ClosedToOpen = &linkedRampUp{}
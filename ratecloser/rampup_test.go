@@ -0,0 +1,178 @@
+package ratecloser
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+	"github.com/cep21/circuit/v3"
+)
+
+type countingOpener struct {
+	neverOpens
+	prevent    bool
+	shouldOpen bool
+	successes  int
+	failures   int
+	closed     int
+}
+
+func (c *countingOpener) Prevent(now time.Time) bool    { return c.prevent }
+func (c *countingOpener) ShouldOpen(now time.Time) bool { return c.shouldOpen }
+func (c *countingOpener) Closed(now time.Time)          { c.closed++ }
+func (c *countingOpener) Success(now time.Time, duration time.Duration) {
+	c.successes++
+}
+func (c *countingOpener) ErrFailure(now time.Time, duration time.Duration) {
+	c.failures++
+}
+
+func TestRampUpConfig(t *testing.T) {
+	c := RampUpConfig{}
+	c.merge(defaultRampUpConfig)
+	if c.Opener == nil {
+		t.Error("expect non nil opener")
+	}
+	if c.Duration == 0 || c.Burst == 0 {
+		t.Error("Expect non zero duration and burst")
+	}
+}
+
+func TestRampUp_noRamp(t *testing.T) {
+	r := RampUpFactory(RampUpConfig{})().(*RampUp)
+	now := time.Now()
+	r.Closed(now)
+	if r.Ramping(now) {
+		t.Error("expect no ramp without an initial rate")
+	}
+	for i := 0; i < 100; i++ {
+		if r.Prevent(now) {
+			t.Error("expect no requests to be prevented without a ramp")
+		}
+	}
+}
+
+func TestRampUp_InitialRate(t *testing.T) {
+	r := RampUpFactory(RampUpConfig{
+		InitialRate: 1,
+		Burst:       2,
+		Duration:    time.Second * 10,
+	})().(*RampUp)
+	now := time.Now()
+	r.Closed(now)
+	if !r.Ramping(now) {
+		t.Fatal("expect a ramp after close")
+	}
+	if r.Prevent(now) || r.Prevent(now) {
+		t.Error("expect the burst to be allowed")
+	}
+	if !r.Prevent(now) {
+		t.Error("expect requests over the ramp's rate to be prevented")
+	}
+	r.Success(now, time.Millisecond)
+	r.Success(now, time.Millisecond)
+	if r.ramp.Rate() != 3 {
+		t.Errorf("expect successes to grow the ramp, got %f", r.ramp.Rate())
+	}
+	r.ErrFailure(now, time.Millisecond)
+	if r.ramp.Rate() != 1.5 {
+		t.Errorf("expect failures to shrink the ramp, got %f", r.ramp.Rate())
+	}
+	now = now.Add(time.Second * 10)
+	for i := 0; i < 100; i++ {
+		if r.Prevent(now) {
+			t.Fatal("expect the ramp to get out of the way after Duration")
+		}
+	}
+	if r.Ramping(now) {
+		t.Error("expect the ramp to be over")
+	}
+}
+
+func TestRampUp_Opened(t *testing.T) {
+	r := RampUpFactory(RampUpConfig{
+		InitialRate: 1,
+	})().(*RampUp)
+	now := time.Now()
+	r.Closed(now)
+	r.Opened(now)
+	if r.Ramping(now) {
+		t.Error("expect opening to end the ramp")
+	}
+}
+
+func TestRampUp_delegates(t *testing.T) {
+	o := &countingOpener{}
+	r := RampUpFactory(RampUpConfig{
+		Opener: func() circuit.ClosedToOpen {
+			return o
+		},
+	})()
+	now := time.Now()
+	r.Success(now, time.Millisecond)
+	r.ErrFailure(now, time.Millisecond)
+	r.Closed(now)
+	if o.successes != 1 || o.failures != 1 || o.closed != 1 {
+		t.Error("expect events to reach the wrapped opener")
+	}
+	if r.ShouldOpen(now) {
+		t.Error("expect ShouldOpen from the wrapped opener")
+	}
+	o.shouldOpen = true
+	if !r.ShouldOpen(now) {
+		t.Error("expect ShouldOpen from the wrapped opener")
+	}
+	o.prevent = true
+	if !r.Prevent(now) {
+		t.Error("expect Prevent from the wrapped opener")
+	}
+}
+
+func TestRampUpCircuitFactory(t *testing.T) {
+	f := RampUpCircuitFactory{
+		CloserConfig: CloserConfig{
+			RateLimiter:          aimdcloser.AIMDConstructor(1, .5, 4, 1),
+			CloseOnHappyDuration: time.Nanosecond,
+		},
+		RampUpConfig: RampUpConfig{
+			Duration: time.Hour,
+			Burst:    1,
+		},
+	}
+	m := circuit.Manager{
+		DefaultCircuitProperties: []circuit.CommandPropertiesConstructor{f.Configure},
+	}
+	c := m.MustCreateCircuit("ramp")
+	closer := c.OpenToClose.(*Closer)
+	ramp := c.ClosedToOpen.(*linkedRampUp)
+	c.OpenCircuit()
+	closer.Success(time.Now(), time.Millisecond)
+	time.Sleep(time.Millisecond)
+	// The closer now allows 5 req / sec and has been happy long enough to close
+	err := c.Execute(context.Background(), func(ctx context.Context) error {
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.IsOpen() {
+		t.Fatal("expect the circuit to close")
+	}
+	if !ramp.Ramping(time.Now()) {
+		t.Fatal("expect the ramp to start once closed")
+	}
+	if ramp.ramp.InitialRate < 5 {
+		t.Errorf("expect the ramp to start from the closer's rate, got %f", ramp.ramp.InitialRate)
+	}
+	// The ramp's burst is 1, so a second request right away is shed
+	_ = c.Execute(context.Background(), func(ctx context.Context) error {
+		return nil
+	}, nil)
+	err = c.Execute(context.Background(), func(ctx context.Context) error {
+		return nil
+	}, nil)
+	if ce, ok := err.(interface{ CircuitOpen() bool }); !ok || !ce.CircuitOpen() {
+		t.Errorf("expect the ramp to shed requests, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package ratecloser
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+)
+
+func TestCloser_ConcurrentRater(t *testing.T) {
+	c := CloserFactory(CloserConfig{
+		RateLimiter:     aimdcloser.ShardedConstructor(1, .5, 1, 4),
+		ConcurrentRater: true,
+	})().(*Closer)
+	if !c.ConcurrentRater {
+		t.Fatal("expected the config to set ConcurrentRater")
+	}
+	now := time.Now()
+	c.Opened(now)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if c.Allow(now) {
+					c.Success(now, 0)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	s := c.Stats(now)
+	if s.Allowed+s.Rejected != 800 {
+		t.Fatalf("expected every request counted, got %d allowed and %d rejected", s.Allowed, s.Rejected)
+	}
+	if s.Rejected == 0 {
+		t.Fatal("expected requests past the burst to be rejected")
+	}
+	if c.ShouldClose(now) {
+		t.Fatal("expected a rejection to restart the happy duration")
+	}
+}
+
+func BenchmarkCloser_AllowParallel(b *testing.B) {
+	for _, procs := range []int{1, 4, 16, 64} {
+		b.Run("procs="+strconv.Itoa(procs), func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+			benchmarkCloserAllow(b, "mutex", CloserConfig{
+				RateLimiter: aimdcloser.AIMDConstructor(1, .5, 1e9, 1e6),
+			})
+			benchmarkCloserAllow(b, "sharded", CloserConfig{
+				RateLimiter:     aimdcloser.ShardedConstructor(1, .5, 1e9, 1e6),
+				ConcurrentRater: true,
+			})
+		})
+	}
+}
+
+// benchmarkCloserAllow allows, then succeeds, a request from every goroutine per op
+func benchmarkCloserAllow(b *testing.B, name string, conf CloserConfig) {
+	b.Run(name, func(b *testing.B) {
+		c := CloserFactory(conf)().(*Closer)
+		c.Opened(time.Now())
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				now := time.Now()
+				if c.Allow(now) {
+					c.Success(now, 0)
+				}
+			}
+		})
+	})
+}
@@ -0,0 +1,29 @@
+// Package rategrpc applies aimdcloser rate limiters to gRPC clients and servers with interceptors.
+package rategrpc
+
+import (
+	"context"
+
+	"github.com/cep21/aimdcloser"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ClassifyError is the default classification of both interceptors.  ResourceExhausted, Unavailable and
+// DeadlineExceeded are failures.  Canceled calls, and codes that point at a bug rather than load (Unknown, Internal,
+// Unimplemented and DataLoss), are ignored.  Every other code is a success, since the backend had room to answer.
+func ClassifyError(err error) aimdcloser.Outcome {
+	if err == context.DeadlineExceeded {
+		return aimdcloser.Failure
+	}
+	if err == context.Canceled {
+		return aimdcloser.Ignore
+	}
+	switch status.Code(err) {
+	case codes.ResourceExhausted, codes.Unavailable, codes.DeadlineExceeded:
+		return aimdcloser.Failure
+	case codes.Canceled, codes.Unknown, codes.Internal, codes.Unimplemented, codes.DataLoss:
+		return aimdcloser.Ignore
+	}
+	return aimdcloser.Success
+}
@@ -0,0 +1,175 @@
+package rategrpc
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+	"google.golang.org/grpc"
+)
+
+// Client rate limits outgoing calls with one rate limiter per connection target.  Calls over the rate of a target
+// fail with ResourceExhausted and are never sent.  Install both of its interceptors
+// with grpc.WithUnaryInterceptor and grpc.WithStreamInterceptor.  It is safe for concurrent use and may be shared
+// between connections.
+type Client struct {
+	// RateLimiter constructs the limiter of each target.  Defaults to aimdcloser.DefaultRateLimiter.
+	RateLimiter func() aimdcloser.RateLimiter
+	// Classify decides what the error of a call tells the limiter.  Defaults to ClassifyError.
+	Classify func(err error) aimdcloser.Outcome
+	// IdleTTL forgets the limiter of targets not used for this long.  Defaults to 10 minutes.
+	IdleTTL time.Duration
+	// Now should simulate time.Now.  Defaults to time.Now.
+	Now func() time.Time
+
+	limiters keyedLimiters
+}
+
+// UnaryClientInterceptor rate limits unary calls
+func (c *Client) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		target := cc.Target()
+		if !c.keyed().AttemptReserve(target, c.now()) {
+			return rejected(target)
+		}
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		c.report(target, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor rate limits the creation of streams.  The outcome of a stream is the error that ends it,
+// including an error from sending, or the context's error if the caller cancels a stream it has not read to the end.
+func (c *Client) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		target := cc.Target()
+		if !c.keyed().AttemptReserve(target, c.now()) {
+			return nil, rejected(target)
+		}
+		s, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			c.report(target, err)
+			return nil, err
+		}
+		cs := &clientStream{
+			ClientStream:  s,
+			serverStreams: desc.ServerStreams,
+			report: func(err error) {
+				c.report(target, err)
+			},
+			done: make(chan struct{}),
+		}
+		if ctx.Done() != nil {
+			go cs.watch(ctx)
+		}
+		return cs, nil
+	}
+}
+
+func (c *Client) keyed() *aimdcloser.Keyed {
+	return c.limiters.get(c.RateLimiter, c.IdleTTL)
+}
+
+func (c *Client) now() time.Time {
+	if c.Now == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
+
+func (c *Client) report(target string, err error) {
+	classify := c.Classify
+	if classify == nil {
+		classify = ClassifyError
+	}
+	switch classify(err) {
+	case aimdcloser.Success:
+		c.keyed().OnSuccess(target, c.now())
+	case aimdcloser.Failure:
+		c.keyed().OnFailure(target, c.now())
+	}
+}
+
+// Rate returns the current rate of target, and false if target has no limiter or its limiter cannot report a rate.
+func (c *Client) Rate(target string) (float64, bool) {
+	return c.keyed().Rate(target)
+}
+
+// clientStream reports the error that ends a stream, once.  A stream the caller abandons without reading it to the
+// end is reported when its context is done.
+type clientStream struct {
+	grpc.ClientStream
+	// serverStreams is false for client streaming RPCs, which end with their only response
+	serverStreams bool
+	report        func(err error)
+	once          sync.Once
+	// done is closed once the stream is reported
+	done chan struct{}
+}
+
+func (s *clientStream) finish(err error) {
+	s.once.Do(func() {
+		close(s.done)
+		s.report(err)
+	})
+}
+
+// watch reports the stream if ctx is done before anything else reports it
+func (s *clientStream) watch(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		s.finish(ctx.Err())
+	case <-s.done:
+	}
+}
+
+func (s *clientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	// io.EOF means the server ended the stream, and RecvMsg returns its status
+	if err != nil && err != io.EOF {
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *clientStream) CloseSend() error {
+	err := s.ClientStream.CloseSend()
+	if err != nil {
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == io.EOF || (err == nil && !s.serverStreams) {
+		s.finish(nil)
+	} else if err != nil {
+		s.finish(err)
+	}
+	return err
+}
+
+// keyedLimiters lazily creates the aimdcloser.Keyed of an interceptor from its configuration
+type keyedLimiters struct {
+	once  sync.Once
+	keyed aimdcloser.Keyed
+}
+
+const defaultIdleTTL = time.Minute * 10
+
+func (k *keyedLimiters) get(rateLimiter func() aimdcloser.RateLimiter, idleTTL time.Duration) *aimdcloser.Keyed {
+	k.once.Do(func() {
+		k.keyed.RateLimiter = rateLimiter
+		if k.keyed.RateLimiter == nil {
+			k.keyed.RateLimiter = aimdcloser.DefaultRateLimiter
+		}
+		k.keyed.IdleTTL = idleTTL
+		if k.keyed.IdleTTL == 0 {
+			k.keyed.IdleTTL = defaultIdleTTL
+		}
+	})
+	return &k.keyed
+}
@@ -0,0 +1,366 @@
+package rategrpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// healthServer answers with whatever error it is told to
+type healthServer struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (h *healthServer) setErr(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.err = err
+}
+
+func (h *healthServer) getErr() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
+
+func (h *healthServer) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	if err := h.getErr(); err != nil {
+		return nil, err
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
+
+func (h *healthServer) Watch(req *grpc_health_v1.HealthCheckRequest, ws grpc_health_v1.Health_WatchServer) error {
+	if err := h.getErr(); err != nil {
+		return err
+	}
+	return ws.Send(&grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING})
+}
+
+// uploadDesc is a client streaming RPC.  The health service has none.
+var uploadDesc = grpc.StreamDesc{
+	StreamName:    "Upload",
+	ClientStreams: true,
+	Handler: func(srv interface{}, ss grpc.ServerStream) error {
+		for {
+			if err := ss.RecvMsg(&grpc_health_v1.HealthCheckRequest{}); err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+		if err := srv.(*healthServer).getErr(); err != nil {
+			return err
+		}
+		return ss.SendMsg(&grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING})
+	},
+}
+
+type testEnv struct {
+	health *healthServer
+	conn   *grpc.ClientConn
+	client grpc_health_v1.HealthClient
+	close  func()
+}
+
+func newTestEnv(t *testing.T, s *Server, c *Client) *testEnv {
+	lis := bufconn.Listen(1024 * 1024)
+	var serverOpts []grpc.ServerOption
+	if s != nil {
+		serverOpts = append(serverOpts, grpc.UnaryInterceptor(s.UnaryServerInterceptor()), grpc.StreamInterceptor(s.StreamServerInterceptor()))
+	}
+	srv := grpc.NewServer(serverOpts...)
+	h := &healthServer{}
+	grpc_health_v1.RegisterHealthServer(srv, h)
+	srv.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "rategrpc.test",
+		HandlerType: (*interface{})(nil),
+		Streams:     []grpc.StreamDesc{uploadDesc},
+	}, h)
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	dialOpts := []grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithDialer(func(string, time.Duration) (net.Conn, error) {
+			return lis.Dial()
+		}),
+	}
+	if c != nil {
+		dialOpts = append(dialOpts, grpc.WithUnaryInterceptor(c.UnaryClientInterceptor()), grpc.WithStreamInterceptor(c.StreamClientInterceptor()))
+	}
+	conn, err := grpc.Dial("bufnet", dialOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testEnv{
+		health: h,
+		conn:   conn,
+		client: grpc_health_v1.NewHealthClient(conn),
+		close: func() {
+			_ = conn.Close()
+			srv.Stop()
+		},
+	}
+}
+
+func (e *testEnv) check() error {
+	_, err := e.client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	return err
+}
+
+func (e *testEnv) watch() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := e.client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		return err
+	}
+	for {
+		if _, err := w.Recv(); err != nil {
+			return err
+		}
+	}
+}
+
+func (e *testEnv) upload() error {
+	s, err := e.conn.NewStream(context.Background(), &uploadDesc, "/rategrpc.test/Upload")
+	if err != nil {
+		return err
+	}
+	if err := s.SendMsg(&grpc_health_v1.HealthCheckRequest{}); err != nil {
+		return err
+	}
+	if err := s.CloseSend(); err != nil {
+		return err
+	}
+	return s.RecvMsg(&grpc_health_v1.HealthCheckResponse{})
+}
+
+const checkMethod = "/grpc.health.v1.Health/Check"
+const watchMethod = "/grpc.health.v1.Health/Watch"
+
+func TestClassifyError(t *testing.T) {
+	expected := map[codes.Code]aimdcloser.Outcome{
+		codes.OK:                aimdcloser.Success,
+		codes.NotFound:          aimdcloser.Success,
+		codes.InvalidArgument:   aimdcloser.Success,
+		codes.ResourceExhausted: aimdcloser.Failure,
+		codes.Unavailable:       aimdcloser.Failure,
+		codes.DeadlineExceeded:  aimdcloser.Failure,
+		codes.Canceled:          aimdcloser.Ignore,
+		codes.Internal:          aimdcloser.Ignore,
+	}
+	for code, outcome := range expected {
+		var err error
+		if code != codes.OK {
+			err = status.Error(code, "test")
+		}
+		if ClassifyError(err) != outcome {
+			t.Errorf("expected %s to be %d", code, outcome)
+		}
+	}
+	if ClassifyError(context.DeadlineExceeded) != aimdcloser.Failure {
+		t.Error("expected context deadlines to be failures")
+	}
+}
+
+func TestServer_Unary(t *testing.T) {
+	s := &Server{
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 100, 100),
+	}
+	e := newTestEnv(t, s, nil)
+	defer e.close()
+	if err := e.check(); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := s.Rate(checkMethod); r != 101 {
+		t.Fatalf("expected success to raise the rate, got %f", r)
+	}
+	e.health.setErr(status.Error(codes.Unavailable, "overloaded"))
+	if err := e.check(); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected the handler's error, got %v", err)
+	}
+	if r, _ := s.Rate(checkMethod); r != 50.5 {
+		t.Fatalf("expected Unavailable to lower the rate, got %f", r)
+	}
+	if _, ok := s.Rate(watchMethod); ok {
+		t.Fatal("expected methods to have their own limiter")
+	}
+}
+
+func TestServer_Rejects(t *testing.T) {
+	now := time.Now()
+	s := &Server{
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 1, 1),
+		Now: func() time.Time {
+			return now
+		},
+	}
+	e := newTestEnv(t, s, nil)
+	defer e.close()
+	if err := e.check(); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.check(); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected the second call to be shed, got %v", err)
+	}
+	if err := e.watch(); err != io.EOF {
+		t.Fatalf("expected watch to have its own limiter, got %v", err)
+	}
+	if err := e.watch(); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected the second stream to be shed, got %v", err)
+	}
+}
+
+func TestServer_Stream(t *testing.T) {
+	s := &Server{
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 100, 100),
+	}
+	e := newTestEnv(t, s, nil)
+	defer e.close()
+	e.health.setErr(status.Error(codes.ResourceExhausted, "full"))
+	if err := e.watch(); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected the handler's error, got %v", err)
+	}
+	if r, _ := s.Rate(watchMethod); r != 50 {
+		t.Fatalf("expected a failed stream to lower the rate, got %f", r)
+	}
+}
+
+func TestClient_Unary(t *testing.T) {
+	c := &Client{
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 100, 100),
+	}
+	e := newTestEnv(t, nil, c)
+	defer e.close()
+	if err := e.check(); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := c.Rate("bufnet"); r != 101 {
+		t.Fatalf("expected success to raise the rate, got %f", r)
+	}
+	e.health.setErr(status.Error(codes.DeadlineExceeded, "slow"))
+	if err := e.check(); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expected the server's error, got %v", err)
+	}
+	if r, _ := c.Rate("bufnet"); r != 50.5 {
+		t.Fatalf("expected DeadlineExceeded to lower the rate, got %f", r)
+	}
+	e.health.setErr(status.Error(codes.NotFound, "missing"))
+	if err := e.check(); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected the server's error, got %v", err)
+	}
+	if r, _ := c.Rate("bufnet"); r != 51.5 {
+		t.Fatalf("expected NotFound to raise the rate, got %f", r)
+	}
+}
+
+func TestClient_Rejects(t *testing.T) {
+	now := time.Now()
+	c := &Client{
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 1, 1),
+		Now: func() time.Time {
+			return now
+		},
+	}
+	e := newTestEnv(t, nil, c)
+	defer e.close()
+	if err := e.check(); err != nil {
+		t.Fatal(err)
+	}
+	err := e.check()
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected a ResourceExhausted code, got %s", status.Code(err))
+	}
+	if msg := status.Convert(err).Message(); msg != (&aimdcloser.RateLimitedError{Key: "bufnet"}).Error() {
+		t.Fatalf("expected the target in the message, got %q", msg)
+	}
+	if _, err := e.client.Watch(context.Background(), &grpc_health_v1.HealthCheckRequest{}); err == nil {
+		t.Fatal("expected streams to share the target's limiter")
+	}
+}
+
+func TestClient_Stream(t *testing.T) {
+	c := &Client{
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 100, 100),
+	}
+	e := newTestEnv(t, nil, c)
+	defer e.close()
+	if err := e.watch(); err != io.EOF {
+		t.Fatalf("expected the stream to end cleanly, got %v", err)
+	}
+	if r, _ := c.Rate("bufnet"); r != 101 {
+		t.Fatalf("expected a stream that ends cleanly to raise the rate, got %f", r)
+	}
+	e.health.setErr(status.Error(codes.Unavailable, "down"))
+	if err := e.watch(); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected the server's error, got %v", err)
+	}
+	if r, _ := c.Rate("bufnet"); r != 50.5 {
+		t.Fatalf("expected a failed stream to lower the rate, got %f", r)
+	}
+	e.health.setErr(nil)
+	if err := e.upload(); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := c.Rate("bufnet"); r != 51.5 {
+		t.Fatalf("expected a client stream that gets its response to raise the rate, got %f", r)
+	}
+	e.health.setErr(status.Error(codes.Unavailable, "down"))
+	if err := e.upload(); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected the server's error, got %v", err)
+	}
+	if r, _ := c.Rate("bufnet"); r != 25.75 {
+		t.Fatalf("expected a failed client stream to lower the rate, got %f", r)
+	}
+}
+
+func TestClient_StreamNotDrained(t *testing.T) {
+	reports := make(chan error, 10)
+	c := &Client{
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 100, 100),
+		Classify: func(err error) aimdcloser.Outcome {
+			reports <- err
+			return ClassifyError(err)
+		},
+	}
+	e := newTestEnv(t, nil, c)
+	defer e.close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	w, err := e.client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-reports:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("expected the context's error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a stream that is never read to the end to be reported")
+	}
+	if _, err := w.Recv(); err == nil {
+		t.Fatal("expected the stream to be over")
+	}
+	select {
+	case err := <-reports:
+		t.Fatalf("expected the stream to be reported once, got %v", err)
+	case <-time.After(time.Millisecond * 10):
+	}
+}
@@ -0,0 +1,86 @@
+package rategrpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server sheds incoming calls with one rate limiter per method.  Rejected calls fail with ResourceExhausted and
+// never reach the handler.  Install both of its interceptors with grpc.UnaryInterceptor and grpc.StreamInterceptor.
+// It is safe for concurrent use.
+type Server struct {
+	// RateLimiter constructs the limiter of each method.  Defaults to aimdcloser.DefaultRateLimiter.
+	RateLimiter func() aimdcloser.RateLimiter
+	// Classify decides what the error a handler returns tells the limiter.  Defaults to ClassifyError.
+	Classify func(err error) aimdcloser.Outcome
+	// IdleTTL forgets the limiter of methods not used for this long.  Defaults to 10 minutes.
+	IdleTTL time.Duration
+	// Now should simulate time.Now.  Defaults to time.Now.
+	Now func() time.Time
+
+	limiters keyedLimiters
+}
+
+// UnaryServerInterceptor sheds unary calls
+func (s *Server) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !s.keyed().AttemptReserve(info.FullMethod, s.now()) {
+			return nil, rejected(info.FullMethod)
+		}
+		resp, err := handler(ctx, req)
+		s.report(info.FullMethod, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor sheds streams.  The outcome of a stream is the error its handler returns.
+func (s *Server) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !s.keyed().AttemptReserve(info.FullMethod, s.now()) {
+			return rejected(info.FullMethod)
+		}
+		err := handler(srv, ss)
+		s.report(info.FullMethod, err)
+		return err
+	}
+}
+
+// rejected is the error of calls whose limiter, of a method or target, does not allow them
+func rejected(key string) error {
+	return status.Error(codes.ResourceExhausted, (&aimdcloser.RateLimitedError{Key: key}).Error())
+}
+
+func (s *Server) keyed() *aimdcloser.Keyed {
+	return s.limiters.get(s.RateLimiter, s.IdleTTL)
+}
+
+func (s *Server) now() time.Time {
+	if s.Now == nil {
+		return time.Now()
+	}
+	return s.Now()
+}
+
+func (s *Server) report(method string, err error) {
+	classify := s.Classify
+	if classify == nil {
+		classify = ClassifyError
+	}
+	switch classify(err) {
+	case aimdcloser.Success:
+		s.keyed().OnSuccess(method, s.now())
+	case aimdcloser.Failure:
+		s.keyed().OnFailure(method, s.now())
+	}
+}
+
+// Rate returns the current rate of a full method name, and false if it has no limiter or its limiter cannot report
+// a rate.
+func (s *Server) Rate(fullMethod string) (float64, bool) {
+	return s.keyed().Rate(fullMethod)
+}
@@ -0,0 +1,58 @@
+package ratehttp_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+	"github.com/cep21/aimdcloser/ratehttp"
+)
+
+func ExampleTransport() {
+	client := &http.Client{
+		Transport: &ratehttp.Transport{
+			// Start each host at 10 requests / sec, bursting to 1
+			RateLimiter: aimdcloser.AIMDConstructor(1, .5, 10, 1),
+		},
+	}
+	_, err := client.Get("http://127.0.0.1:1/")
+	fmt.Println(err != nil)
+	// The first request used the only burst, so the second one never leaves the process
+	_, err = client.Get("http://127.0.0.1:1/")
+	if urlErr, ok := err.(*url.Error); ok {
+		_, isRateLimited := urlErr.Err.(*aimdcloser.RateLimitedError)
+		fmt.Println(isRateLimited)
+	}
+	// Output: true
+	// true
+}
+
+func ExampleHandler() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("hello"))
+	})
+	shed := &ratehttp.Handler{
+		Next: mux,
+		// Responses slower than a second mean we are overloaded
+		LatencyThreshold: time.Second,
+	}
+	// Serve the status outside of the middleware, so it still answers while shedding load
+	root := http.NewServeMux()
+	root.Handle("/", shed)
+	root.Handle("/debug/ratehttp", shed.StatusHandler())
+	s := httptest.NewServer(root)
+	defer s.Close()
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		panic(err)
+	}
+	_ = resp.Body.Close()
+	fmt.Println(resp.StatusCode)
+	fmt.Println(shed.Status().Allowed)
+	// Output: 200
+	// 1
+}
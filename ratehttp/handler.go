@@ -0,0 +1,253 @@
+package ratehttp
+
+import (
+	"bufio"
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+)
+
+// ClassifyServed is the default classification of Handler.  5xx responses, and responses slower than
+// latencyThreshold if it is set, are failures.  Every other response is a success.
+func ClassifyServed(status int, latency time.Duration, latencyThreshold time.Duration) aimdcloser.Outcome {
+	if status >= 500 {
+		return aimdcloser.Failure
+	}
+	if latencyThreshold > 0 && latency > latencyThreshold {
+		return aimdcloser.Failure
+	}
+	return aimdcloser.Success
+}
+
+// Handler is middleware that sheds load with an adaptive rate limiter.  Requests over the rate get a 503 with a
+// Retry-After header and never reach Next.  The outcome of every request that does reach Next is reported to the
+// limiter, so the rate follows how well Next copes.  It is safe for concurrent use.
+type Handler struct {
+	// Next serves requests that are allowed
+	Next http.Handler
+	// RateLimiter constructs the single limiter every request goes through.  It does not need to be safe for
+	// concurrent use.  Defaults to aimdcloser.DefaultRateLimiter.
+	RateLimiter func() aimdcloser.RateLimiter
+	// LatencyThreshold counts responses slower than this as failures.  A default of zero ignores latency.
+	LatencyThreshold time.Duration
+	// Classify decides what a served response tells the limiter.  Defaults to ClassifyServed with LatencyThreshold.
+	Classify func(status int, latency time.Duration) aimdcloser.Outcome
+	// Now should simulate time.Now.  Defaults to time.Now.
+	Now func() time.Time
+
+	once     sync.Once
+	rater    aimdcloser.SyncRateLimiter
+	allowed  int64
+	rejected int64
+}
+
+var _ http.Handler = &Handler{}
+
+func (h *Handler) init() {
+	h.once.Do(func() {
+		constructor := h.RateLimiter
+		if constructor == nil {
+			constructor = aimdcloser.DefaultRateLimiter
+		}
+		h.rater.RateLimiter = constructor()
+	})
+}
+
+func (h *Handler) now() time.Time {
+	if h.Now == nil {
+		return time.Now()
+	}
+	return h.Now()
+}
+
+func (h *Handler) classify(status int, latency time.Duration) aimdcloser.Outcome {
+	if h.Classify == nil {
+		return ClassifyServed(status, latency, h.LatencyThreshold)
+	}
+	return h.Classify(status, latency)
+}
+
+// retryAfter is how many whole seconds until the limiter likely has room for another request
+func (h *Handler) retryAfter() int {
+	rate := h.rater.Rate()
+	if math.IsNaN(rate) || rate <= 0 {
+		return 1
+	}
+	return int(math.Max(1, math.Ceil(1/rate)))
+}
+
+// ServeHTTP sends the request to Next if the limiter allows it, or responds with 503 if not.
+func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	h.init()
+	start := h.now()
+	if !h.rater.AttemptReserve(start) {
+		atomic.AddInt64(&h.rejected, 1)
+		rw.Header().Set("Retry-After", strconv.Itoa(h.retryAfter()))
+		http.Error(rw, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	atomic.AddInt64(&h.allowed, 1)
+	sw := &statusWriter{ResponseWriter: rw, status: http.StatusOK}
+	h.Next.ServeHTTP(sw.wrap(), req)
+	end := h.now()
+	if sw.hijacked {
+		// Next took over the connection (a websocket, say), so the status and latency mean nothing
+		return
+	}
+	switch h.classify(sw.status, end.Sub(start)) {
+	case aimdcloser.Success:
+		h.rater.OnSuccess(end)
+	case aimdcloser.Failure:
+		h.rater.OnFailure(end)
+	}
+}
+
+// Status is the current state of a Handler
+type Status struct {
+	// Rate is the current rate of requests / sec.  It is nil if the limiter cannot report a rate, or if the rate
+	// is infinite, since JSON cannot represent either.
+	Rate *float64 `json:"rate"`
+	// Burst is how many requests can happen at once, or zero if the limiter cannot report it
+	Burst int `json:"burst"`
+	// Allowed is how many requests reached Next
+	Allowed int64 `json:"allowed"`
+	// Rejected is how many requests were shed
+	Rejected int64 `json:"rejected"`
+}
+
+// Status returns the current state of the handler
+func (h *Handler) Status() Status {
+	h.init()
+	ret := Status{
+		Burst:    h.rater.MaxBurst(),
+		Allowed:  atomic.LoadInt64(&h.allowed),
+		Rejected: atomic.LoadInt64(&h.rejected),
+	}
+	// rate.Inf is math.MaxFloat64, so limiters built on x/time/rate report that instead of +Inf
+	if rate := h.rater.Rate(); !math.IsNaN(rate) && !math.IsInf(rate, 0) && rate < math.MaxFloat64 {
+		ret.Rate = &rate
+	}
+	return ret
+}
+
+// StatusHandler returns a handler that writes Status as JSON.  Mount it somewhere that is not itself behind h.
+func (h *Handler) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(rw).Encode(h.Status()); err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// statusWriter remembers the status code Next responded with
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	hijacked    bool
+}
+
+func (s *statusWriter) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusWriter) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(b)
+}
+
+// wrap returns s as a ResponseWriter with the same optional http.Flusher, http.Hijacker and http.Pusher interfaces
+// as the writer it wraps, so handlers that check for them behave as they would without Handler
+func (s *statusWriter) wrap() http.ResponseWriter {
+	_, isFlusher := s.ResponseWriter.(http.Flusher)
+	_, isHijacker := s.ResponseWriter.(http.Hijacker)
+	_, isPusher := s.ResponseWriter.(http.Pusher)
+	f, h, p := flusher{s}, hijacker{s}, pusher{s}
+	switch {
+	case isFlusher && isHijacker && isPusher:
+		return struct {
+			*statusWriter
+			flusher
+			hijacker
+			pusher
+		}{s, f, h, p}
+	case isFlusher && isHijacker:
+		return struct {
+			*statusWriter
+			flusher
+			hijacker
+		}{s, f, h}
+	case isFlusher && isPusher:
+		return struct {
+			*statusWriter
+			flusher
+			pusher
+		}{s, f, p}
+	case isHijacker && isPusher:
+		return struct {
+			*statusWriter
+			hijacker
+			pusher
+		}{s, h, p}
+	case isFlusher:
+		return struct {
+			*statusWriter
+			flusher
+		}{s, f}
+	case isHijacker:
+		return struct {
+			*statusWriter
+			hijacker
+		}{s, h}
+	case isPusher:
+		return struct {
+			*statusWriter
+			pusher
+		}{s, p}
+	}
+	return s
+}
+
+type flusher struct {
+	s *statusWriter
+}
+
+// Flush passes through to the wrapped writer
+func (f flusher) Flush() {
+	f.s.wroteHeader = true
+	f.s.ResponseWriter.(http.Flusher).Flush()
+}
+
+type hijacker struct {
+	s *statusWriter
+}
+
+// Hijack passes through to the wrapped writer
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := h.s.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		h.s.hijacked = true
+	}
+	return conn, rw, err
+}
+
+type pusher struct {
+	s *statusWriter
+}
+
+// Push passes through to the wrapped writer
+func (p pusher) Push(target string, opts *http.PushOptions) error {
+	return p.s.ResponseWriter.(http.Pusher).Push(target, opts)
+}
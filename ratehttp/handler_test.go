@@ -0,0 +1,203 @@
+package ratehttp
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+)
+
+func serve(h http.Handler) *httptest.ResponseRecorder {
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+	return rw
+}
+
+func TestHandler_Sheds(t *testing.T) {
+	now := time.Now()
+	served := 0
+	h := &Handler{
+		Next: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			served++
+		}),
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, .25, 2),
+		Now: func() time.Time {
+			return now
+		},
+	}
+	for i := 0; i < 2; i++ {
+		if rw := serve(h); rw.Code != http.StatusOK {
+			t.Fatalf("expected request %d to be allowed, got %d", i, rw.Code)
+		}
+	}
+	rw := serve(h)
+	if rw.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected the burst to be used up, got %d", rw.Code)
+	}
+	// Two successes raised the rate to 2.25 / sec
+	if ra := rw.Header().Get("Retry-After"); ra != "1" {
+		t.Fatalf("unexpected Retry-After %q", ra)
+	}
+	if served != 2 {
+		t.Fatalf("rejected requests should not be served, got %d", served)
+	}
+	s := h.Status()
+	if s.Allowed != 2 || s.Rejected != 1 || s.Burst != 2 || s.Rate == nil || *s.Rate != 2.25 {
+		t.Fatalf("unexpected status %+v", s)
+	}
+}
+
+func TestHandler_RetryAfter(t *testing.T) {
+	now := time.Now()
+	h := &Handler{
+		Next:        http.NotFoundHandler(),
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, .1, 0),
+		Now: func() time.Time {
+			return now
+		},
+	}
+	rw := serve(h)
+	if rw.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected a zero burst to reject, got %d", rw.Code)
+	}
+	if ra := rw.Header().Get("Retry-After"); ra != "10" {
+		t.Fatalf("expected to retry after one request at .1 / sec, got %q", ra)
+	}
+}
+
+func TestHandler_Classification(t *testing.T) {
+	now := time.Now()
+	status := http.StatusOK
+	latency := time.Duration(0)
+	h := &Handler{
+		Next: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			now = now.Add(latency)
+			if status != http.StatusOK {
+				rw.WriteHeader(status)
+			}
+			_, _ = rw.Write([]byte("hello"))
+		}),
+		RateLimiter:      aimdcloser.AIMDConstructor(1, .5, 100, 100),
+		LatencyThreshold: time.Second,
+		Now: func() time.Time {
+			return now
+		},
+	}
+	steps := []struct {
+		status  int
+		latency time.Duration
+		rate    float64
+	}{
+		{http.StatusOK, 0, 101},
+		{http.StatusNotFound, 0, 102},
+		{http.StatusInternalServerError, 0, 51},
+		{http.StatusOK, time.Second * 2, 25.5},
+		{http.StatusOK, time.Millisecond, 26.5},
+	}
+	for _, step := range steps {
+		status = step.status
+		latency = step.latency
+		if rw := serve(h); rw.Code != step.status {
+			t.Fatalf("expected status %d, got %d", step.status, rw.Code)
+		}
+		if s := h.Status(); s.Rate == nil || *s.Rate != step.rate {
+			t.Fatalf("after %d in %s expected rate %f, got %+v", step.status, step.latency, step.rate, s)
+		}
+	}
+}
+
+func TestHandler_Classify(t *testing.T) {
+	h := &Handler{
+		Next:        http.NotFoundHandler(),
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 100, 100),
+		Classify: func(status int, latency time.Duration) aimdcloser.Outcome {
+			if status == http.StatusNotFound {
+				return aimdcloser.Failure
+			}
+			return aimdcloser.Ignore
+		},
+	}
+	serve(h)
+	if s := h.Status(); s.Rate == nil || *s.Rate != 50 {
+		t.Fatalf("expected custom classification to fail 404, got %+v", s)
+	}
+}
+
+func TestHandler_StatusHandler(t *testing.T) {
+	h := &Handler{
+		Next:        http.NotFoundHandler(),
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 5, 10),
+	}
+	serve(h)
+	rw := serve(h.StatusHandler())
+	if ct := rw.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+	var s Status
+	if err := json.Unmarshal(rw.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Rate == nil || *s.Rate != 6 {
+		t.Fatalf("unexpected rate %v", s.Rate)
+	}
+	if s.Burst != 10 || s.Allowed != 1 || s.Rejected != 0 {
+		t.Fatalf("unexpected status %+v", s)
+	}
+}
+
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (h hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, nil
+}
+
+func TestHandler_OptionalInterfaces(t *testing.T) {
+	var flushes, hijacks, pushes bool
+	h := &Handler{
+		Next: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			_, flushes = rw.(http.Flusher)
+			_, hijacks = rw.(http.Hijacker)
+			_, pushes = rw.(http.Pusher)
+			if hj, ok := rw.(http.Hijacker); ok {
+				if _, _, err := hj.Hijack(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}),
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 10, 10),
+		Classify: func(status int, latency time.Duration) aimdcloser.Outcome {
+			t.Fatal("hijacked requests should not be classified")
+			return aimdcloser.Ignore
+		},
+	}
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(hijackRecorder{rw}, httptest.NewRequest("GET", "/", nil))
+	if !flushes || !hijacks || pushes {
+		t.Fatalf("expected Flusher and Hijacker only, got flush=%v hijack=%v push=%v", flushes, hijacks, pushes)
+	}
+
+	h.Next = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, flushes = rw.(http.Flusher)
+		_, hijacks = rw.(http.Hijacker)
+		rw.WriteHeader(http.StatusTeapot)
+	})
+	var classified int
+	h.Classify = func(status int, latency time.Duration) aimdcloser.Outcome {
+		classified = status
+		return aimdcloser.Ignore
+	}
+	serve(h)
+	if !flushes || hijacks {
+		t.Fatalf("a recorder only flushes, got flush=%v hijack=%v", flushes, hijacks)
+	}
+	if classified != http.StatusTeapot {
+		t.Fatalf("expected the status to reach Classify, got %d", classified)
+	}
+}
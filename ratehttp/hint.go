@@ -0,0 +1,62 @@
+package ratehttp
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+)
+
+// HintFromResponse reads capacity hints from the headers of resp.  Retry-After, in seconds or as a date, pauses
+// until then.  X-RateLimit-Remaining with X-RateLimit-Reset spreads the remaining requests until the reset, or
+// pauses until the reset if none remain.  X-RateLimit-Reset is read as a unix time if it is large enough to be one,
+// and as seconds from now otherwise.  Returns false if resp has no hint.
+func HintFromResponse(resp *http.Response, now time.Time) (aimdcloser.Hint, bool) {
+	var ret aimdcloser.Hint
+	found := false
+	if until, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now); ok {
+		ret.PauseUntil = until
+		found = true
+	}
+	remaining, err1 := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Remaining"), 64)
+	reset, err2 := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Reset"), 64)
+	if err1 != nil || err2 != nil || remaining < 0 {
+		return ret, found
+	}
+	resetAt := now.Add(time.Duration(reset * float64(time.Second)))
+	if reset > unixTimeCutoff {
+		resetAt = time.Unix(int64(reset), 0)
+	}
+	window := resetAt.Sub(now)
+	if window <= 0 {
+		return ret, found
+	}
+	if remaining == 0 {
+		if resetAt.After(ret.PauseUntil) {
+			ret.PauseUntil = resetAt
+		}
+	} else {
+		ret.Rate = remaining / window.Seconds()
+	}
+	return ret, true
+}
+
+// unixTimeCutoff is about a decade of seconds.  Larger X-RateLimit-Reset values are unix times.
+const unixTimeCutoff = 3e8
+
+func parseRetryAfter(value string, now time.Time) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return time.Time{}, false
+		}
+		return now.Add(time.Duration(seconds) * time.Second), true
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at, true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,92 @@
+package ratehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+)
+
+func TestHintFromResponse(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	type testCase struct {
+		headers map[string]string
+		hint    aimdcloser.Hint
+		ok      bool
+	}
+	cases := []testCase{
+		{
+			headers: map[string]string{},
+		},
+		{
+			headers: map[string]string{"Retry-After": "2"},
+			hint:    aimdcloser.Hint{PauseUntil: now.Add(time.Second * 2)},
+			ok:      true,
+		},
+		{
+			headers: map[string]string{"Retry-After": now.Add(time.Minute).UTC().Format(http.TimeFormat)},
+			hint:    aimdcloser.Hint{PauseUntil: now.Add(time.Minute)},
+			ok:      true,
+		},
+		{
+			headers: map[string]string{"Retry-After": "soon"},
+		},
+		{
+			headers: map[string]string{"X-RateLimit-Remaining": "20", "X-RateLimit-Reset": "10"},
+			hint:    aimdcloser.Hint{Rate: 2},
+			ok:      true,
+		},
+		{
+			headers: map[string]string{"X-RateLimit-Remaining": "10", "X-RateLimit-Reset": strconv.FormatInt(now.Unix()+20, 10)},
+			hint:    aimdcloser.Hint{Rate: .5},
+			ok:      true,
+		},
+		{
+			headers: map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "30"},
+			hint:    aimdcloser.Hint{PauseUntil: now.Add(time.Second * 30)},
+			ok:      true,
+		},
+		{
+			headers: map[string]string{"X-RateLimit-Remaining": "10"},
+		},
+	}
+	for _, c := range cases {
+		resp := &http.Response{Header: http.Header{}}
+		for k, v := range c.headers {
+			resp.Header.Set(k, v)
+		}
+		hint, ok := HintFromResponse(resp, now)
+		if ok != c.ok || hint.Rate != c.hint.Rate || !hint.PauseUntil.Equal(c.hint.PauseUntil) {
+			t.Errorf("headers %v: expected %+v %v, got %+v %v", c.headers, c.hint, c.ok, hint, ok)
+		}
+	}
+}
+
+func TestTransport_Hint(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Retry-After", "5")
+		rw.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer s.Close()
+	now := time.Now()
+	tr := &Transport{
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 100, 100),
+		Now: func() time.Time {
+			return now
+		},
+	}
+	c := &http.Client{Transport: tr}
+	if _, err := get(t, c, s.URL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(t, c, s.URL); err == nil {
+		t.Fatal("expected Retry-After to pause the host")
+	}
+	now = now.Add(time.Second * 5)
+	if _, err := get(t, c, s.URL); err != nil {
+		t.Fatalf("expected the pause to end, got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package ratehttp
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+)
+
+// ClassifyResponse is the default classification of Transport.  Connection errors, 429 and 503 are failures.
+// Other 5xx responses are ignored, and every other response is a success.  Errors after the request's context
+// ends are ignored, since the caller gave up and that says nothing about the backend.
+func ClassifyResponse(req *http.Request, resp *http.Response, err error) aimdcloser.Outcome {
+	if err != nil {
+		if req.Context().Err() != nil {
+			return aimdcloser.Ignore
+		}
+		return aimdcloser.Failure
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusServiceUnavailable:
+		return aimdcloser.Failure
+	case resp.StatusCode >= 500:
+		return aimdcloser.Ignore
+	}
+	return aimdcloser.Success
+}
+
+// Transport is a http.RoundTripper that rate limits requests per host with an adaptive rate limiter.  Requests
+// over the rate are rejected locally with a *aimdcloser.RateLimitedError whose Key is the host.  It is safe for
+// concurrent use.
+type Transport struct {
+	// Base sends requests that are allowed.  Defaults to http.DefaultTransport.
+	Base http.RoundTripper
+	// RateLimiter constructs the limiter of each host.  Defaults to aimdcloser.DefaultRateLimiter.
+	RateLimiter func() aimdcloser.RateLimiter
+	// Classify decides what a response tells the limiter.  Defaults to ClassifyResponse.
+	Classify func(req *http.Request, resp *http.Response, err error) aimdcloser.Outcome
+	// Hint reads capacity hints from a response, which go to the host's limiter if it is a
+	// aimdcloser.RateHinter.  Defaults to HintFromResponse.
+	Hint func(resp *http.Response, now time.Time) (aimdcloser.Hint, bool)
+	// IdleTTL forgets the limiter of hosts not used for this long.  Defaults to 10 minutes.
+	IdleTTL time.Duration
+	// Now should simulate time.Now.  Defaults to time.Now.
+	Now func() time.Time
+
+	once  sync.Once
+	hosts aimdcloser.Keyed
+}
+
+const defaultIdleTTL = time.Minute * 10
+
+var _ http.RoundTripper = &Transport{}
+
+func (t *Transport) init() {
+	t.once.Do(func() {
+		t.hosts.RateLimiter = t.RateLimiter
+		if t.hosts.RateLimiter == nil {
+			t.hosts.RateLimiter = aimdcloser.DefaultRateLimiter
+		}
+		t.hosts.IdleTTL = t.IdleTTL
+		if t.hosts.IdleTTL == 0 {
+			t.hosts.IdleTTL = defaultIdleTTL
+		}
+	})
+}
+
+func (t *Transport) now() time.Time {
+	if t.Now == nil {
+		return time.Now()
+	}
+	return t.Now()
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base == nil {
+		return http.DefaultTransport
+	}
+	return t.Base
+}
+
+func (t *Transport) classify(req *http.Request, resp *http.Response, err error) aimdcloser.Outcome {
+	if t.Classify == nil {
+		return ClassifyResponse(req, resp, err)
+	}
+	return t.Classify(req, resp, err)
+}
+
+// RoundTrip sends req if the limiter of its host allows it, and reports the outcome to that limiter.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.init()
+	host := req.URL.Host
+	if !t.hosts.AttemptReserve(host, t.now()) {
+		if req.Body != nil {
+			// RoundTrip must always close the body, even on errors
+			_ = req.Body.Close()
+		}
+		return nil, &aimdcloser.RateLimitedError{Key: host}
+	}
+	resp, err := t.base().RoundTrip(req)
+	switch t.classify(req, resp, err) {
+	case aimdcloser.Success:
+		t.hosts.OnSuccess(host, t.now())
+	case aimdcloser.Failure:
+		t.hosts.OnFailure(host, t.now())
+	}
+	if err == nil {
+		t.hint(host, resp)
+	}
+	return resp, err
+}
+
+func (t *Transport) hint(host string, resp *http.Response) {
+	now := t.now()
+	var hint aimdcloser.Hint
+	var ok bool
+	if t.Hint == nil {
+		hint, ok = HintFromResponse(resp, now)
+	} else {
+		hint, ok = t.Hint(resp, now)
+	}
+	if ok {
+		t.hosts.OnHint(host, now, hint)
+	}
+}
+
+// Rate returns the current rate of host, and false if host has no limiter or its limiter cannot report a rate.
+func (t *Transport) Rate(host string) (float64, bool) {
+	t.init()
+	return t.hosts.Rate(host)
+}
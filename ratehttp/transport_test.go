@@ -0,0 +1,202 @@
+package ratehttp
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+)
+
+func statusServer(status *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(int(atomic.LoadInt32(status)))
+	}))
+}
+
+func get(t *testing.T, c *http.Client, u string) (*http.Response, error) {
+	resp, err := c.Get(u)
+	if err == nil {
+		_, _ = ioutil.ReadAll(resp.Body)
+		if err := resp.Body.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp, err
+}
+
+func hostOf(t *testing.T, u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Host
+}
+
+func TestTransport_Classification(t *testing.T) {
+	status := int32(http.StatusOK)
+	s := statusServer(&status)
+	defer s.Close()
+	tr := &Transport{
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 100, 100),
+	}
+	c := &http.Client{Transport: tr}
+	host := hostOf(t, s.URL)
+	steps := []struct {
+		status int32
+		rate   float64
+	}{
+		{http.StatusOK, 101},
+		{http.StatusNotFound, 102},
+		{http.StatusTooManyRequests, 51},
+		{http.StatusServiceUnavailable, 25.5},
+		{http.StatusInternalServerError, 25.5},
+		{http.StatusNoContent, 26.5},
+	}
+	for _, step := range steps {
+		atomic.StoreInt32(&status, step.status)
+		if _, err := get(t, c, s.URL); err != nil {
+			t.Fatal(err)
+		}
+		r, ok := tr.Rate(host)
+		if !ok || r != step.rate {
+			t.Fatalf("after %d expected rate %f, got %f %v", step.status, step.rate, r, ok)
+		}
+	}
+}
+
+func TestTransport_ConnectionError(t *testing.T) {
+	s := httptest.NewServer(http.NotFoundHandler())
+	u := s.URL
+	s.Close()
+	tr := &Transport{
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 100, 100),
+	}
+	c := &http.Client{Transport: tr}
+	if _, err := get(t, c, u); err == nil {
+		t.Fatal("expected a connection error")
+	}
+	if r, _ := tr.Rate(hostOf(t, u)); r != 50 {
+		t.Fatalf("expected connection errors to be failures, got rate %f", r)
+	}
+}
+
+func TestTransport_Rejects(t *testing.T) {
+	var hits int32
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer s.Close()
+	now := time.Now()
+	tr := &Transport{
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 1, 2),
+		Now: func() time.Time {
+			return now
+		},
+	}
+	c := &http.Client{Transport: tr}
+	for i := 0; i < 2; i++ {
+		if _, err := get(t, c, s.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := get(t, c, s.URL)
+	if err == nil {
+		t.Fatal("expected the third request to be rate limited")
+	}
+	urlErr, ok := err.(*url.Error)
+	if !ok {
+		t.Fatalf("expected a *url.Error, got %T", err)
+	}
+	rateErr, ok := urlErr.Err.(*aimdcloser.RateLimitedError)
+	if !ok {
+		t.Fatalf("expected a *aimdcloser.RateLimitedError, got %T", urlErr.Err)
+	}
+	if rateErr.Key != hostOf(t, s.URL) {
+		t.Fatalf("unexpected host %s", rateErr.Key)
+	}
+	if atomic.LoadInt32(&hits) != 2 {
+		t.Fatalf("rejected requests should not reach the server, got %d hits", hits)
+	}
+}
+
+func TestTransport_PerHost(t *testing.T) {
+	status := int32(http.StatusTooManyRequests)
+	bad := statusServer(&status)
+	defer bad.Close()
+	good := httptest.NewServer(http.NotFoundHandler())
+	defer good.Close()
+	tr := &Transport{
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 100, 100),
+	}
+	c := &http.Client{Transport: tr}
+	for _, u := range []string{bad.URL, good.URL} {
+		if _, err := get(t, c, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r, _ := tr.Rate(hostOf(t, bad.URL)); r != 50 {
+		t.Fatalf("expected bad host to slow down, got %f", r)
+	}
+	if r, _ := tr.Rate(hostOf(t, good.URL)); r != 101 {
+		t.Fatalf("expected good host to speed up, got %f", r)
+	}
+}
+
+func TestTransport_Classify(t *testing.T) {
+	s := httptest.NewServer(http.NotFoundHandler())
+	defer s.Close()
+	tr := &Transport{
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 100, 100),
+		Classify: func(req *http.Request, resp *http.Response, err error) aimdcloser.Outcome {
+			if err == nil && resp.StatusCode == http.StatusNotFound {
+				return aimdcloser.Failure
+			}
+			return ClassifyResponse(req, resp, err)
+		},
+	}
+	c := &http.Client{Transport: tr}
+	if _, err := get(t, c, s.URL); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := tr.Rate(hostOf(t, s.URL)); r != 50 {
+		t.Fatalf("expected custom classification to fail 404, got %f", r)
+	}
+}
+
+type closeTracker struct {
+	closed bool
+}
+
+func (c *closeTracker) Read(p []byte) (int, error) {
+	return 0, errors.New("should not be read")
+}
+
+func (c *closeTracker) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestTransport_RejectClosesBody(t *testing.T) {
+	tr := &Transport{
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 1, 0),
+	}
+	body := &closeTracker{}
+	req, err := http.NewRequest("POST", "http://example.com", strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Body = body
+	if _, err := tr.RoundTrip(req); err == nil {
+		t.Fatal("expected a zero burst to reject")
+	}
+	if !body.closed {
+		t.Fatal("expected the request body to be closed")
+	}
+}
@@ -0,0 +1,35 @@
+package rateopener_test
+
+import (
+	"time"
+
+	"github.com/cep21/aimdcloser/ratecloser"
+	"github.com/cep21/aimdcloser/rateopener"
+	"github.com/cep21/circuit/v3"
+)
+
+func ExampleOpenerFactory() {
+	// Tell your circuit manager to shed load adaptively while closed, and to rate limit while open
+	m := circuit.Manager{
+		DefaultCircuitProperties: []circuit.CommandPropertiesConstructor{
+			func(_ string) circuit.Config {
+				return circuit.Config{
+					General: circuit.GeneralConfig{
+						ClosedToOpenFactory: rateopener.OpenerFactory(rateopener.OpenerConfig{
+							RateFloor:     1,
+							FloorDuration: time.Second * 10,
+						}),
+						OpenToClosedFactory: ratecloser.CloserFactory(ratecloser.CloserConfig{
+							CloseOnHappyDuration: time.Second * 10,
+						}),
+					},
+				}
+			},
+		},
+	}
+	// Make circuit from manager
+	c := m.MustCreateCircuit("example_circuit")
+	// The opener should be an opener of this type
+	_ = c.ClosedToOpen.(*rateopener.Opener)
+	// Output:
+}
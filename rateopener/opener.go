@@ -0,0 +1,154 @@
+package rateopener
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+
+	"github.com/cep21/circuit/v3"
+)
+
+// Opener is a circuit opener that sheds load according to a rate limiter while the circuit is closed, and opens
+// the circuit once the learned rate has stayed below a floor for long enough.
+type Opener struct {
+	// Rater is the rate limiter of this opener.  The circuit only opens if Rater is an aimdcloser.RateReporter.
+	Rater aimdcloser.RateLimiter
+	// RateFloor is the requests / sec below which the rate is considered collapsed
+	RateFloor float64
+	// FloorDuration is how long the rate must stay below RateFloor before the circuit opens
+	FloorDuration time.Duration
+	// belowFloorSince is when the rate went below RateFloor, or zero if it is above
+	belowFloorSince time.Time
+	mu              sync.Mutex
+}
+
+// OpenerConfig configures defaults for Opener.
+type OpenerConfig struct {
+	// RateLimiter constructs new rate limiters for circuits.  We default to a reasonable AIMD configuration.
+	// That configuration happens to be AIMDConstructor(1, .9, 1/time.Millisecond.Seconds(), 100) right now.
+	RateLimiter func() aimdcloser.RateLimiter
+	// RateFloor is the requests / sec below which the circuit considers the rate collapsed.
+	// We default to a reasonable low value.  It happens to be 1 request / sec right now.
+	RateFloor float64
+	// FloorDuration is how long the rate must stay under RateFloor before the circuit opens.
+	// We default to a reasonable short value.  It happens to be 10 seconds right now.
+	FloorDuration time.Duration
+}
+
+func (o *OpenerConfig) merge(other OpenerConfig) {
+	if o.RateLimiter == nil {
+		o.RateLimiter = other.RateLimiter
+	}
+	if o.RateFloor == 0 {
+		o.RateFloor = other.RateFloor
+	}
+	if o.FloorDuration == 0 {
+		o.FloorDuration = other.FloorDuration
+	}
+}
+
+var defaultConfig = OpenerConfig{
+	RateLimiter:   aimdcloser.AIMDConstructor(1, .9, 1/time.Millisecond.Seconds(), 100),
+	RateFloor:     1,
+	FloorDuration: time.Second * 10,
+}
+
+// OpenerFactory is injectable into a circuit's configuration to create a factory of rate limit openers for a circuit.
+func OpenerFactory(conf OpenerConfig) func() circuit.ClosedToOpen {
+	return func() circuit.ClosedToOpen {
+		c := conf
+		c.merge(defaultConfig)
+		return &Opener{
+			Rater:         c.RateLimiter(),
+			RateFloor:     c.RateFloor,
+			FloorDuration: c.FloorDuration,
+		}
+	}
+}
+
+// checkFloor updates when the rate went below RateFloor.  It must be called with the mutex held after any rate change.
+func (o *Opener) checkFloor(now time.Time) {
+	r, ok := o.Rater.(aimdcloser.RateReporter)
+	if !ok || r.Rate() >= o.RateFloor {
+		o.belowFloorSince = time.Time{}
+		return
+	}
+	if o.belowFloorSince.IsZero() {
+		o.belowFloorSince = now
+	}
+}
+
+// Success sends the rater a success message.
+func (o *Opener) Success(now time.Time, duration time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.Rater.OnSuccess(now)
+	o.checkFloor(now)
+}
+
+// ErrFailure sends the rater a failure message.
+func (o *Opener) ErrFailure(now time.Time, duration time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.Rater.OnFailure(now)
+	o.checkFloor(now)
+}
+
+// ErrTimeout sends the rater a failure message.
+func (o *Opener) ErrTimeout(now time.Time, duration time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.Rater.OnFailure(now)
+	o.checkFloor(now)
+}
+
+// ErrBadRequest is ignored and exists only to satisfy the opener interface.
+func (o *Opener) ErrBadRequest(now time.Time, duration time.Duration) {
+}
+
+// ErrInterrupt is ignored and exists only to satisfy the opener interface.
+func (o *Opener) ErrInterrupt(now time.Time, duration time.Duration) {
+}
+
+// ErrConcurrencyLimitReject is ignored and exists only to satisfy the opener interface.
+func (o *Opener) ErrConcurrencyLimitReject(now time.Time) {
+}
+
+// ErrShortCircuit is ignored and exists only to satisfy the opener interface.
+func (o *Opener) ErrShortCircuit(now time.Time) {
+}
+
+// Closed resets the rater
+func (o *Opener) Closed(now time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.Rater.Reset(now)
+	o.belowFloorSince = time.Time{}
+}
+
+// Opened resets the rater
+func (o *Opener) Opened(now time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.Rater.Reset(now)
+	o.belowFloorSince = time.Time{}
+}
+
+// ShouldOpen returns true if the rate has been below RateFloor for at least FloorDuration.
+func (o *Opener) ShouldOpen(now time.Time) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return !o.belowFloorSince.IsZero() && now.Sub(o.belowFloorSince) >= o.FloorDuration
+}
+
+// Prevent attempts to get a reservation from the rater.  Requests over the learned rate are prevented and
+// return to the caller as short circuits.
+func (o *Opener) Prevent(now time.Time) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return !o.Rater.AttemptReserve(now)
+}
+
+// Type check we are implementing the correct types for our opener
+var _ circuit.ClosedToOpen = &Opener{}
@@ -0,0 +1,176 @@
+package rateopener
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+	"github.com/cep21/circuit/v3"
+)
+
+func TestOpenerConfig(t *testing.T) {
+	c := OpenerConfig{}
+	c.merge(defaultConfig)
+	if c.RateLimiter == nil {
+		t.Error("expect non nil rate limiter")
+	}
+	if c.RateFloor == 0 {
+		t.Error("Expect non zero rate floor")
+	}
+	if c.FloorDuration == 0 {
+		t.Error("Expect non zero floor duration")
+	}
+}
+
+func TestOpenerFactory(t *testing.T) {
+	// Compile test to verify we implement the correct type for circuit.
+	_ = circuit.GeneralConfig{
+		ClosedToOpenFactory: OpenerFactory(defaultConfig),
+	}
+	t.Run("default", func(t *testing.T) {
+		opener := OpenerFactory(OpenerConfig{})().(*Opener)
+		if opener.FloorDuration != defaultConfig.FloorDuration {
+			t.Error("Expect to get floor duration from default")
+		}
+		if opener.Rater == nil {
+			t.Error("Expect to get a non nil rater")
+		}
+	})
+	t.Run("explicit", func(t *testing.T) {
+		opener := OpenerFactory(OpenerConfig{
+			RateFloor: 5,
+		})().(*Opener)
+		if opener.RateFloor != 5 {
+			t.Error("Expect to get rate floor from explicit set")
+		}
+	})
+}
+
+func TestOpener_Prevent(t *testing.T) {
+	factory := OpenerFactory(OpenerConfig{
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 10, 5),
+	})
+	t.Run("sheds_over_burst", func(t *testing.T) {
+		opener := factory()
+		now := time.Now()
+		for i := 0; i < 5; i++ {
+			if opener.Prevent(now) {
+				t.Error("Expect to allow requests in the burst range")
+			}
+		}
+		if !opener.Prevent(now) {
+			t.Error("Expect to prevent requests over the burst")
+		}
+	})
+	t.Run("sheds_more_after_failures", func(t *testing.T) {
+		opener := factory().(*Opener)
+		now := time.Now()
+		opener.Closed(now)
+		for i := 0; i < 3; i++ {
+			opener.ErrFailure(now, time.Millisecond)
+		}
+		// Rate is now 1.25 / sec, so a new token takes 800ms
+		for i := 0; i < 5; i++ {
+			opener.Prevent(now)
+		}
+		if !opener.Prevent(now.Add(time.Millisecond * 500)) {
+			t.Error("Expect the lower rate to prevent requests")
+		}
+		if opener.Prevent(now.Add(time.Second)) {
+			t.Error("Expect the lower rate to eventually allow requests")
+		}
+	})
+}
+
+func TestOpener_ShouldOpen(t *testing.T) {
+	factory := OpenerFactory(OpenerConfig{
+		RateLimiter:   aimdcloser.AIMDConstructor(1, .5, 10, 5),
+		RateFloor:     2,
+		FloorDuration: time.Second * 5,
+	})
+	t.Run("atstart", func(t *testing.T) {
+		opener := factory()
+		if opener.ShouldOpen(time.Now()) {
+			t.Error("Expect not to open at start")
+		}
+	})
+	t.Run("collapsed", func(t *testing.T) {
+		opener := factory()
+		now := time.Now()
+		for i := 0; i < 3; i++ {
+			opener.ErrFailure(now, time.Millisecond)
+		}
+		if opener.ShouldOpen(now.Add(time.Second * 4)) {
+			t.Error("Expect not to open before FloorDuration")
+		}
+		if !opener.ShouldOpen(now.Add(time.Second * 5)) {
+			t.Error("Expect to open after FloorDuration below the floor")
+		}
+	})
+	t.Run("recovered", func(t *testing.T) {
+		opener := factory()
+		now := time.Now()
+		for i := 0; i < 3; i++ {
+			opener.ErrTimeout(now, time.Millisecond)
+		}
+		opener.Success(now.Add(time.Second), time.Millisecond)
+		if opener.ShouldOpen(now.Add(time.Second * 10)) {
+			t.Error("Expect not to open once the rate recovers above the floor")
+		}
+		opener.ErrFailure(now.Add(time.Second*10), time.Millisecond)
+		if opener.ShouldOpen(now.Add(time.Second * 14)) {
+			t.Error("Expect the floor duration to restart after recovering")
+		}
+	})
+	t.Run("reset", func(t *testing.T) {
+		opener := factory()
+		now := time.Now()
+		for i := 0; i < 3; i++ {
+			opener.ErrFailure(now, time.Millisecond)
+		}
+		opener.Opened(now)
+		if opener.ShouldOpen(now.Add(time.Second * 10)) {
+			t.Error("Expect Opened to reset the floor")
+		}
+	})
+}
+
+func TestOpener_ignored(t *testing.T) {
+	opener := OpenerFactory(OpenerConfig{
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 10, 5),
+	})().(*Opener)
+	now := time.Now()
+	startingRate := opener.Rater.(*aimdcloser.AIMD).Rate()
+	opener.ErrBadRequest(now, time.Millisecond)
+	opener.ErrInterrupt(now, time.Millisecond)
+	opener.ErrConcurrencyLimitReject(now)
+	opener.ErrShortCircuit(now)
+	if opener.Rater.(*aimdcloser.AIMD).Rate() != startingRate {
+		t.Error("expected rate to stay the same")
+	}
+}
+
+func TestOpener_Circuit(t *testing.T) {
+	m := circuit.Manager{}
+	c := m.MustCreateCircuit("opener", circuit.Config{
+		General: circuit.GeneralConfig{
+			ClosedToOpenFactory: OpenerFactory(OpenerConfig{
+				RateLimiter:   aimdcloser.AIMDConstructor(1, .1, 10, 10),
+				RateFloor:     1,
+				FloorDuration: time.Nanosecond,
+			}),
+		},
+	})
+	// Zero delay failures quickly collapse the rate and open the circuit
+	for i := 0; i < 5 && !c.IsOpen(); i++ {
+		_ = c.Execute(context.Background(), func(ctx context.Context) error {
+			return errors.New("bad")
+		}, nil)
+		time.Sleep(time.Millisecond)
+	}
+	if !c.IsOpen() {
+		t.Error("expected the circuit to open after the rate collapsed")
+	}
+}
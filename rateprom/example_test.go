@@ -0,0 +1,17 @@
+package rateprom_test
+
+import (
+	"net/http"
+
+	"github.com/cep21/aimdcloser/rateprom"
+	"github.com/cep21/circuit/v3"
+)
+
+func ExampleHandler() {
+	m := circuit.Manager{}
+	// Expose every rate limited closer of the manager to a Prometheus scraper
+	http.Handle("/metrics", &rateprom.Handler{
+		Manager: &m,
+	})
+	// Output:
+}
@@ -0,0 +1,154 @@
+package rateprom
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cep21/aimdcloser/ratecloser"
+	"github.com/cep21/circuit/v3"
+)
+
+// StatsCloser is any circuit closer that can report ratecloser.Stats.  *ratecloser.Closer is a StatsCloser.
+type StatsCloser interface {
+	Stats(now time.Time) ratecloser.Stats
+}
+
+var _ StatsCloser = &ratecloser.Closer{}
+
+// Handler is a http.Handler that writes the state of every rate limited closer in Manager using the Prometheus
+// text exposition format.  Circuits whose closer is not a StatsCloser are skipped.
+type Handler struct {
+	// Manager holds the circuits to export
+	Manager *circuit.Manager
+	// Namespace is prefixed to every metric name.  Defaults to "aimdcloser".
+	Namespace string
+	// Now should simulate time.Now.  Defaults to time.Now.
+	Now func() time.Time
+}
+
+var _ http.Handler = &Handler{}
+
+type metric struct {
+	name  string
+	help  string
+	kind  string
+	value func(s ratecloser.Stats) float64
+}
+
+var metrics = []metric{
+	{
+		name:  "closer_rate",
+		help:  "Current requests per second the closer's rate limiter allows.",
+		kind:  "gauge",
+		value: func(s ratecloser.Stats) float64 { return s.Rate },
+	},
+	{
+		name:  "closer_burst",
+		help:  "Current burst size of the closer's rate limiter.",
+		kind:  "gauge",
+		value: func(s ratecloser.Stats) float64 { return float64(s.Burst) },
+	},
+	{
+		name:  "closer_happy_progress",
+		help:  "Fraction of the happy duration passed without a failure or rejection.",
+		kind:  "gauge",
+		value: func(s ratecloser.Stats) float64 { return s.HappyProgress },
+	},
+	{
+		name:  "closer_allowed_total",
+		help:  "Requests the closer has allowed.",
+		kind:  "counter",
+		value: func(s ratecloser.Stats) float64 { return float64(s.Allowed) },
+	},
+	{
+		name:  "closer_rejected_total",
+		help:  "Requests the closer has rejected.",
+		kind:  "counter",
+		value: func(s ratecloser.Stats) float64 { return float64(s.Rejected) },
+	},
+}
+
+type circuitStats struct {
+	name  string
+	stats ratecloser.Stats
+}
+
+func (h *Handler) now() time.Time {
+	if h.Now == nil {
+		return time.Now()
+	}
+	return h.Now()
+}
+
+func (h *Handler) namespace() string {
+	if h.Namespace == "" {
+		return "aimdcloser"
+	}
+	return h.Namespace
+}
+
+func (h *Handler) collect() []circuitStats {
+	now := h.now()
+	circuits := h.Manager.AllCircuits()
+	ret := make([]circuitStats, 0, len(circuits))
+	for _, c := range circuits {
+		sc, ok := c.OpenToClose.(StatsCloser)
+		if !ok {
+			continue
+		}
+		ret = append(ret, circuitStats{
+			name:  c.Name(),
+			stats: sc.Stats(now),
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].name < ret[j].name
+	})
+	return ret
+}
+
+// ServeHTTP writes every metric in the Prometheus text format
+func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	// Nothing useful can be done with a failed write to the client
+	_ = h.write(rw)
+}
+
+func (h *Handler) write(w io.Writer) error {
+	stats := h.collect()
+	bw := bufio.NewWriter(w)
+	prefix := h.namespace() + "_"
+	for _, m := range metrics {
+		name := prefix + m.name
+		bw.WriteString("# HELP " + name + " " + m.help + "\n")
+		bw.WriteString("# TYPE " + name + " " + m.kind + "\n")
+		for _, s := range stats {
+			bw.WriteString(name + `{circuit="` + escapeLabel(s.name) + `"} ` + formatValue(m.value(s.stats)) + "\n")
+		}
+	}
+	return bw.Flush()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,178 @@
+package rateprom
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+	"github.com/cep21/aimdcloser/ratecloser"
+	"github.com/cep21/circuit/v3"
+)
+
+// parseExposition reads Prometheus text format into a map of `name{labels}` to value, and a map of name to type
+func parseExposition(t *testing.T, r io.Reader) (map[string]float64, map[string]string) {
+	t.Helper()
+	values := make(map[string]float64)
+	types := make(map[string]string)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(line, "# TYPE ") {
+			parts := strings.Fields(line)
+			types[parts[2]] = parts[3]
+			continue
+		}
+		if strings.HasPrefix(line, "#") || line == "" {
+			continue
+		}
+		idx := strings.LastIndex(line, " ")
+		if idx == -1 {
+			t.Fatalf("invalid line %q", line)
+		}
+		v, err := strconv.ParseFloat(line[idx+1:], 64)
+		if err != nil {
+			t.Fatalf("invalid value in line %q: %s", line, err)
+		}
+		values[line[:idx]] = v
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return values, types
+}
+
+func expectValue(t *testing.T, values map[string]float64, key string, expected float64) {
+	t.Helper()
+	v, exists := values[key]
+	if !exists {
+		t.Errorf("expected metric %s to exist", key)
+		return
+	}
+	if math.Abs(v-expected) > .00001 && !(math.IsInf(v, 1) && math.IsInf(expected, 1)) {
+		t.Errorf("Unexpected value for %s.  Expected %f Given %f", key, expected, v)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	now := time.Now()
+	m := circuit.Manager{}
+	factory := ratecloser.CloserFactory(ratecloser.CloserConfig{
+		RateLimiter:          aimdcloser.AIMDConstructor(1, .5, 10, 2),
+		CloseOnHappyDuration: time.Second * 10,
+	})
+	c := m.MustCreateCircuit("first", circuit.Config{
+		General: circuit.GeneralConfig{
+			OpenToClosedFactory: factory,
+		},
+	})
+	m.MustCreateCircuit(`weird "name"`, circuit.Config{
+		General: circuit.GeneralConfig{
+			OpenToClosedFactory: factory,
+		},
+	})
+	// Circuits without a rate closer should not be exported
+	m.MustCreateCircuit("not_rate_limited")
+	closer := c.OpenToClose.(*ratecloser.Closer)
+	closer.Opened(now)
+	closer.Allow(now)
+	closer.Allow(now)
+	closer.Allow(now)
+	closer.Success(now, time.Millisecond)
+
+	h := &Handler{
+		Manager: &m,
+		Now: func() time.Time {
+			return now.Add(time.Second * 5)
+		},
+	}
+	s := httptest.NewServer(h)
+	defer s.Close()
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		expectNilErr(t, resp.Body.Close())
+	}()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("unexpected content type %s", resp.Header.Get("Content-Type"))
+	}
+	values, types := parseExposition(t, resp.Body)
+
+	expectValue(t, values, `aimdcloser_closer_rate{circuit="first"}`, 11)
+	expectValue(t, values, `aimdcloser_closer_burst{circuit="first"}`, 2)
+	expectValue(t, values, `aimdcloser_closer_happy_progress{circuit="first"}`, .5)
+	expectValue(t, values, `aimdcloser_closer_allowed_total{circuit="first"}`, 2)
+	expectValue(t, values, `aimdcloser_closer_rejected_total{circuit="first"}`, 1)
+	expectValue(t, values, `aimdcloser_closer_rate{circuit="weird \"name\""}`, 10)
+	expectValue(t, values, `aimdcloser_closer_allowed_total{circuit="weird \"name\""}`, 0)
+	for k := range values {
+		if strings.Contains(k, "not_rate_limited") {
+			t.Errorf("did not expect circuit without rate closer: %s", k)
+		}
+	}
+	if types["aimdcloser_closer_rate"] != "gauge" {
+		t.Error("expected rate to be a gauge")
+	}
+	if types["aimdcloser_closer_allowed_total"] != "counter" {
+		t.Error("expected allowed to be a counter")
+	}
+}
+
+func TestHandler_Namespace(t *testing.T) {
+	m := circuit.Manager{}
+	m.MustCreateCircuit("c", circuit.Config{
+		General: circuit.GeneralConfig{
+			OpenToClosedFactory: ratecloser.CloserFactory(ratecloser.CloserConfig{
+				RateLimiter: aimdcloser.AIMDConstructor(1, .5, 0, 2),
+			}),
+		},
+	})
+	rec := httptest.NewRecorder()
+	h := &Handler{
+		Manager:   &m,
+		Namespace: "myapp",
+	}
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	values, _ := parseExposition(t, rec.Body)
+	expectValue(t, values, `myapp_closer_rate{circuit="c"}`, math.Inf(1))
+}
+
+func TestHandler_empty(t *testing.T) {
+	rec := httptest.NewRecorder()
+	h := &Handler{}
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	values, types := parseExposition(t, rec.Body)
+	if len(values) != 0 {
+		t.Error("expected no values without a manager")
+	}
+	if len(types) != len(metrics) {
+		t.Error("expected every metric to be described")
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	if formatValue(math.NaN()) != "NaN" {
+		t.Error("expected NaN")
+	}
+	if formatValue(math.Inf(-1)) != "-Inf" {
+		t.Error("expected -Inf")
+	}
+	if formatValue(1.5) != "1.5" {
+		t.Error("expected 1.5")
+	}
+}
+
+func expectNilErr(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Error(err.Error())
+	}
+}
@@ -0,0 +1,241 @@
+package ratesql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+)
+
+// conn rate limits queries and execs.  It implements every optional interface database/sql looks for, and falls
+// back the way database/sql would when the wrapped connection does not implement one.
+type conn struct {
+	driver.Conn
+	d *Driver
+}
+
+var _ driver.Conn = &conn{}
+var _ driver.ConnPrepareContext = &conn{}
+var _ driver.ConnBeginTx = &conn{}
+var _ driver.ExecerContext = &conn{}
+var _ driver.QueryerContext = &conn{}
+var _ driver.Pinger = &conn{}
+var _ driver.SessionResetter = &conn{}
+var _ driver.NamedValueChecker = &conn{}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	s, err := c.Conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{Stmt: s, conn: c.Conn, d: c.d}, nil
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	pc, ok := c.Conn.(driver.ConnPrepareContext)
+	if !ok {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return c.Prepare(query)
+	}
+	s, err := pc.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{Stmt: s, conn: c.Conn, d: c.d}, nil
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if bt, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return bt.BeginTx(ctx, opts)
+	}
+	// The same checks database/sql does for drivers without BeginTx
+	if opts.Isolation != 0 {
+		return nil, errors.New("sql: driver does not support non-default isolation level")
+	}
+	if opts.ReadOnly {
+		return nil, errors.New("sql: driver does not support read-only transactions")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Conn.Begin()
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ec, hasExecerContext := c.Conn.(driver.ExecerContext)
+	e, hasExecer := c.Conn.(driver.Execer)
+	if !hasExecerContext && !hasExecer {
+		// database/sql prepares a statement instead, which we rate limit
+		return nil, driver.ErrSkip
+	}
+	token, err := c.d.reserve()
+	if err != nil {
+		return nil, err
+	}
+	var res driver.Result
+	if hasExecerContext {
+		res, err = ec.ExecContext(ctx, query, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValuesToValues(args); err == nil {
+			if err = ctx.Err(); err == nil {
+				res, err = e.Exec(query, values)
+			}
+		}
+	}
+	c.d.report(token, err)
+	return res, err
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	qc, hasQueryerContext := c.Conn.(driver.QueryerContext)
+	q, hasQueryer := c.Conn.(driver.Queryer)
+	if !hasQueryerContext && !hasQueryer {
+		// database/sql prepares a statement instead, which we rate limit
+		return nil, driver.ErrSkip
+	}
+	token, err := c.d.reserve()
+	if err != nil {
+		return nil, err
+	}
+	var rows driver.Rows
+	if hasQueryerContext {
+		rows, err = qc.QueryContext(ctx, query, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValuesToValues(args); err == nil {
+			if err = ctx.Err(); err == nil {
+				rows, err = q.Query(query, values)
+			}
+		}
+	}
+	c.d.report(token, err)
+	return rows, err
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if sr, ok := c.Conn.(driver.SessionResetter); ok {
+		return sr.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nvc, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return nvc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// stmt rate limits the execs and queries of a prepared statement
+type stmt struct {
+	driver.Stmt
+	// conn is the wrapped connection that prepared the statement
+	conn driver.Conn
+	d    *Driver
+}
+
+var _ driver.Stmt = &stmt{}
+var _ driver.StmtExecContext = &stmt{}
+var _ driver.StmtQueryContext = &stmt{}
+var _ driver.NamedValueChecker = &stmt{}
+var _ driver.ColumnConverter = &stmt{}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	token, err := s.d.reserve()
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.Stmt.Exec(args)
+	s.d.report(token, err)
+	return res, err
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	token, err := s.d.reserve()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.Stmt.Query(args)
+	s.d.report(token, err)
+	return rows, err
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	sec, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		values, err := namedValuesToValues(args)
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return s.Exec(values)
+	}
+	token, err := s.d.reserve()
+	if err != nil {
+		return nil, err
+	}
+	res, err := sec.ExecContext(ctx, args)
+	s.d.report(token, err)
+	return res, err
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	sqc, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		values, err := namedValuesToValues(args)
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return s.Query(values)
+	}
+	token, err := s.d.reserve()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := sqc.QueryContext(ctx, args)
+	s.d.report(token, err)
+	return rows, err
+}
+
+// CheckNamedValue checks with the statement, then the connection, like database/sql does
+func (s *stmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if nvc, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return nvc.CheckNamedValue(nv)
+	}
+	if nvc, ok := s.conn.(driver.NamedValueChecker); ok {
+		return nvc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func (s *stmt) ColumnConverter(idx int) driver.ValueConverter {
+	if cc, ok := s.Stmt.(driver.ColumnConverter); ok {
+		return cc.ColumnConverter(idx)
+	}
+	return driver.DefaultParameterConverter
+}
+
+func namedValuesToValues(named []driver.NamedValue) ([]driver.Value, error) {
+	ret := make([]driver.Value, len(named))
+	for i, n := range named {
+		if n.Name != "" {
+			return nil, errors.New("sql: driver does not support the use of Named Parameters")
+		}
+		ret[i] = n.Value
+	}
+	return ret, nil
+}
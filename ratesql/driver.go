@@ -0,0 +1,194 @@
+// Package ratesql puts an adaptive rate limiter in front of the queries and execs of any database/sql driver.
+package ratesql
+
+import (
+	"context"
+	"database/sql/driver"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+	"golang.org/x/time/rate"
+)
+
+// overloadMessages are parts of error messages that databases use when they are overloaded
+var overloadMessages = []string{
+	"too many connections",
+	"too many clients",
+	"timeout",
+	"timed out",
+}
+
+// ClassifyError is the default classification of Driver.  Timeouts, and errors whose message says the database
+// has too many connections or clients, are failures.  Canceled queries and driver.ErrBadConn, which database/sql
+// retries on another connection, are ignored.  Every other result is a success, since the database had room to
+// answer even if the query was wrong.
+func ClassifyError(err error) aimdcloser.Outcome {
+	switch err {
+	case nil:
+		return aimdcloser.Success
+	case context.Canceled, driver.ErrBadConn:
+		return aimdcloser.Ignore
+	case context.DeadlineExceeded:
+		return aimdcloser.Failure
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return aimdcloser.Failure
+	}
+	msg := strings.ToLower(err.Error())
+	for _, overload := range overloadMessages {
+		if strings.Contains(msg, overload) {
+			return aimdcloser.Failure
+		}
+	}
+	return aimdcloser.Success
+}
+
+// Driver wraps another driver, rate limiting every query and exec of every connection it opens with one shared
+// limiter.  Register it with sql.Register, or see WrapConnector.  It is safe for concurrent use.
+//
+// Rows are not watched once a query returns them, so errors while iterating rows do not change the rate.
+// Transactions themselves are not limited, but the queries run inside them are.
+type Driver struct {
+	// Driver is the wrapped driver
+	Driver driver.Driver
+	// RateLimiter constructs the single limiter every query goes through.  It does not need to be safe for
+	// concurrent use.  Defaults to aimdcloser.DefaultRateLimiter.
+	RateLimiter func() aimdcloser.RateLimiter
+	// Classify decides what the error of a query tells the limiter.  Defaults to ClassifyError.
+	Classify func(err error) aimdcloser.Outcome
+	// Now should simulate time.Now.  Defaults to time.Now.
+	Now func() time.Time
+
+	once  sync.Once
+	rater aimdcloser.SyncRateLimiter
+}
+
+var _ driver.Driver = &Driver{}
+var _ driver.DriverContext = &Driver{}
+
+func (d *Driver) init() {
+	d.once.Do(func() {
+		constructor := d.RateLimiter
+		if constructor == nil {
+			constructor = aimdcloser.DefaultRateLimiter
+		}
+		d.rater.RateLimiter = constructor()
+	})
+}
+
+func (d *Driver) now() time.Time {
+	if d.Now == nil {
+		return time.Now()
+	}
+	return d.Now()
+}
+
+// reserve returns aimdcloser.ErrRateLimited if the limiter does not allow another query.  The reservation is nil if
+// the limiter is not an aimdcloser.Reserver, since there is then no way to give the query back.
+func (d *Driver) reserve() (*rate.Reservation, error) {
+	d.init()
+	now := d.now()
+	if _, ok := d.rater.RateLimiter.(aimdcloser.Reserver); !ok {
+		if !d.rater.AttemptReserve(now) {
+			return nil, aimdcloser.ErrRateLimited
+		}
+		return nil, nil
+	}
+	res := d.rater.ReserveN(now, 1)
+	if !res.OK() {
+		return nil, aimdcloser.ErrRateLimited
+	}
+	if res.DelayFrom(now) > 0 {
+		res.CancelAt(now)
+		return nil, aimdcloser.ErrRateLimited
+	}
+	return res, nil
+}
+
+// report tells the limiter how the query of res went.  driver.ErrSkip means the query never ran, and database/sql
+// will prepare it instead, so res is given back rather than charging the query twice.
+func (d *Driver) report(res *rate.Reservation, err error) {
+	if err == driver.ErrSkip {
+		if res != nil {
+			res.CancelAt(d.now())
+		}
+		return
+	}
+	classify := d.Classify
+	if classify == nil {
+		classify = ClassifyError
+	}
+	switch classify(err) {
+	case aimdcloser.Success:
+		d.rater.OnSuccess(d.now())
+	case aimdcloser.Failure:
+		d.rater.OnFailure(d.now())
+	}
+}
+
+// Open opens a connection of the wrapped driver
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, d: d}, nil
+}
+
+// OpenConnector returns a connector for name.  It uses the wrapped driver's connector if it has one.
+func (d *Driver) OpenConnector(name string) (driver.Connector, error) {
+	if dc, ok := d.Driver.(driver.DriverContext); ok {
+		c, err := dc.OpenConnector(name)
+		if err != nil {
+			return nil, err
+		}
+		return d.WrapConnector(c), nil
+	}
+	return d.WrapConnector(dsnConnector{name: name, d: d.Driver}), nil
+}
+
+// WrapConnector returns a connector whose connections are rate limited by d.  Use it with sql.OpenDB for drivers
+// that are configured with a connector instead of a name.  The wrapped driver of d is not used.
+func (d *Driver) WrapConnector(c driver.Connector) driver.Connector {
+	return &connector{Connector: c, d: d}
+}
+
+// Rate returns the current rate, or NaN if the limiter cannot report it
+func (d *Driver) Rate() float64 {
+	d.init()
+	return d.rater.Rate()
+}
+
+type connector struct {
+	driver.Connector
+	d *Driver
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	cn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: cn, d: c.d}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.d
+}
+
+// dsnConnector is the connector of drivers that do not have their own
+type dsnConnector struct {
+	name string
+	d    driver.Driver
+}
+
+func (t dsnConnector) Connect(_ context.Context) (driver.Conn, error) {
+	return t.d.Open(t.name)
+}
+
+func (t dsnConnector) Driver() driver.Driver {
+	return t.d
+}
@@ -0,0 +1,366 @@
+package ratesql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+)
+
+// fakeDB is the state shared by every connection of a fake driver
+type fakeDB struct {
+	mu      sync.Mutex
+	err     error
+	execs   int
+	queries int
+}
+
+func (f *fakeDB) setErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func (f *fakeDB) exec() (driver.Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.execs++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (f *fakeDB) query() (driver.Rows, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &fakeRows{}, nil
+}
+
+func (f *fakeDB) counts() (int, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.execs, f.queries
+}
+
+// fakeDriver opens connections that only prepare statements, unless withContext or skips is set
+type fakeDriver struct {
+	db          *fakeDB
+	withContext bool
+	skips       bool
+}
+
+func (f *fakeDriver) Open(name string) (driver.Conn, error) {
+	c := &fakeConn{db: f.db}
+	if f.skips {
+		return &fakeSkipConn{fakeConn: c}, nil
+	}
+	if f.withContext {
+		return &fakeContextConn{fakeConn: c}, nil
+	}
+	return c, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) { return &fakeStmt{db: c.db}, nil }
+func (c *fakeConn) Close() error                              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+// fakeContextConn runs queries without preparing them
+type fakeContextConn struct {
+	*fakeConn
+}
+
+func (c *fakeContextConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.db.exec()
+}
+
+func (c *fakeContextConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.db.query()
+}
+
+// fakeSkipConn asks database/sql to prepare every query, like drivers that only run queries without arguments
+// directly
+type fakeSkipConn struct {
+	*fakeConn
+}
+
+func (c *fakeSkipConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return nil, driver.ErrSkip
+}
+
+func (c *fakeSkipConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return nil, driver.ErrSkip
+}
+
+type fakeStmt struct {
+	db *fakeDB
+}
+
+func (s *fakeStmt) Close() error                                    { return nil }
+func (s *fakeStmt) NumInput() int                                   { return -1 }
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) { return s.db.exec() }
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error)  { return s.db.query() }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string              { return []string{"n"} }
+func (fakeRows) Close() error                   { return nil }
+func (fakeRows) Next(dest []driver.Value) error { return io.EOF }
+
+func openDB(t *testing.T, d *Driver) *sql.DB {
+	c, err := d.OpenConnector("fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sql.OpenDB(c)
+}
+
+func TestClassifyError(t *testing.T) {
+	expected := map[error]aimdcloser.Outcome{
+		nil:                      aimdcloser.Success,
+		errors.New("syntax"):     aimdcloser.Success,
+		context.Canceled:         aimdcloser.Ignore,
+		driver.ErrBadConn:        aimdcloser.Ignore,
+		context.DeadlineExceeded: aimdcloser.Failure,
+		errors.New("Error 1040: Too many connections"):      aimdcloser.Failure,
+		errors.New("pq: sorry, too many clients already"):   aimdcloser.Failure,
+		errors.New("Lock wait timeout exceeded"):            aimdcloser.Failure,
+		errors.New("read tcp 10.0.0.1:5432: i/o timed out"): aimdcloser.Failure,
+	}
+	for err, outcome := range expected {
+		if ClassifyError(err) != outcome {
+			t.Errorf("expected %v to be %d", err, outcome)
+		}
+	}
+}
+
+func testDriver(t *testing.T, withContext bool) {
+	f := &fakeDB{}
+	d := &Driver{
+		Driver:      &fakeDriver{db: f, withContext: withContext},
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 100, 100),
+	}
+	db := openDB(t, d)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if _, err := db.Exec("INSERT", 1); err != nil {
+		t.Fatal(err)
+	}
+	if d.Rate() != 101 {
+		t.Fatalf("expected a success to raise the rate once, got %f", d.Rate())
+	}
+	rows, err := db.Query("SELECT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if d.Rate() != 102 {
+		t.Fatalf("expected a query to raise the rate once, got %f", d.Rate())
+	}
+	f.setErr(errors.New("too many connections"))
+	if _, err := db.Exec("INSERT"); err == nil {
+		t.Fatal("expected the driver's error")
+	}
+	if d.Rate() != 51 {
+		t.Fatalf("expected too many connections to lower the rate, got %f", d.Rate())
+	}
+	if execs, queries := f.counts(); execs != 2 || queries != 1 {
+		t.Fatalf("unexpected calls to the driver %d %d", execs, queries)
+	}
+}
+
+func TestDriver_Context(t *testing.T) {
+	testDriver(t, true)
+}
+
+func TestDriver_Prepared(t *testing.T) {
+	testDriver(t, false)
+}
+
+func TestDriver_Rejects(t *testing.T) {
+	f := &fakeDB{}
+	now := time.Now()
+	d := &Driver{
+		Driver:      &fakeDriver{db: f, withContext: true},
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 1, 2),
+		Now: func() time.Time {
+			return now
+		},
+	}
+	db := openDB(t, d)
+	for i := 0; i < 2; i++ {
+		if _, err := db.Exec("INSERT"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("INSERT"); err != aimdcloser.ErrRateLimited {
+		t.Fatalf("expected aimdcloser.ErrRateLimited, got %v", err)
+	}
+	if _, err := db.Query("SELECT"); err != aimdcloser.ErrRateLimited {
+		t.Fatalf("expected aimdcloser.ErrRateLimited, got %v", err)
+	}
+	if execs, queries := f.counts(); execs != 2 || queries != 0 {
+		t.Fatalf("rejected calls should not reach the driver, got %d %d", execs, queries)
+	}
+}
+
+func TestDriver_Skip(t *testing.T) {
+	f := &fakeDB{}
+	now := time.Now()
+	d := &Driver{
+		Driver:      &fakeDriver{db: f, skips: true},
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 1, 1),
+		Now: func() time.Time {
+			return now
+		},
+	}
+	db := openDB(t, d)
+	// A burst of one is enough, since the skipped exec gives its token back before the statement takes it
+	if _, err := db.Exec("INSERT", 1); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Second)
+	rows, err := db.Query("SELECT", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if execs, queries := f.counts(); execs != 1 || queries != 1 {
+		t.Fatalf("unexpected calls to the driver %d %d", execs, queries)
+	}
+	if d.Rate() != 3 {
+		t.Fatalf("expected each query to be reported once, got %f", d.Rate())
+	}
+}
+
+func TestDriver_Prepare(t *testing.T) {
+	f := &fakeDB{}
+	d := &Driver{
+		Driver:      &fakeDriver{db: f, withContext: true},
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 100, 100),
+	}
+	db := openDB(t, d)
+	s, err := db.Prepare("INSERT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := s.Exec(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d.Rate() != 103 {
+		t.Fatalf("expected prepared statements to be rate limited, got %f", d.Rate())
+	}
+	if _, err := db.Exec("INSERT", sql.Named("a", 1)); err != nil {
+		t.Fatalf("expected the context connection to accept named values, got %v", err)
+	}
+}
+
+func TestDriver_Classify(t *testing.T) {
+	f := &fakeDB{}
+	d := &Driver{
+		Driver:      &fakeDriver{db: f},
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 100, 100),
+		Classify: func(err error) aimdcloser.Outcome {
+			if err != nil {
+				return aimdcloser.Failure
+			}
+			return aimdcloser.Ignore
+		},
+	}
+	db := openDB(t, d)
+	if _, err := db.Exec("INSERT"); err != nil {
+		t.Fatal(err)
+	}
+	if d.Rate() != 100 {
+		t.Fatalf("expected success to be ignored, got %f", d.Rate())
+	}
+	f.setErr(errors.New("syntax error"))
+	if _, err := db.Exec("INSERT"); err == nil {
+		t.Fatal("expected the driver's error")
+	}
+	if d.Rate() != 50 {
+		t.Fatalf("expected custom classification to fail, got %f", d.Rate())
+	}
+}
+
+// switchDriver lets tests register one driver name, yet use a new Driver each run
+type switchDriver struct {
+	mu sync.Mutex
+	d  *Driver
+}
+
+func (s *switchDriver) set(d *Driver) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.d = d
+}
+
+func (s *switchDriver) Open(name string) (driver.Conn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.d.Open(name)
+}
+
+var registerOnce sync.Once
+var registered = &switchDriver{}
+
+func TestDriver_Register(t *testing.T) {
+	f := &fakeDB{}
+	d := &Driver{
+		Driver:      &fakeDriver{db: f},
+		RateLimiter: aimdcloser.AIMDConstructor(1, .5, 100, 100),
+	}
+	// sql.Register panics if a name is registered twice, which -count would do
+	registerOnce.Do(func() {
+		sql.Register("ratesql_test", registered)
+	})
+	registered.set(d)
+	db, err := sql.Open("ratesql_test", "fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("INSERT"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if d.Rate() != 101 {
+		t.Fatalf("expected queries inside transactions to be rate limited, got %f", d.Rate())
+	}
+	if _, err := db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true}); err == nil {
+		t.Fatal("expected the fake driver not to support read only transactions")
+	}
+}
@@ -0,0 +1,84 @@
+package ratesync
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileStore is a Store in a directory, for instances on a single host.  Each instance writes only its own file,
+// <Dir>/<key>/<instance>.json, replacing it atomically, so no locking between processes is needed.
+type FileStore struct {
+	// Dir is the directory rates are stored in.  It is created if it does not exist.
+	Dir string
+}
+
+const fileStoreExt = ".json"
+
+func (f *FileStore) keyDir(key string) string {
+	return filepath.Join(f.Dir, url.PathEscape(key))
+}
+
+// Publish writes rate to the file of rate.Instance
+func (f *FileStore) Publish(ctx context.Context, key string, rate InstanceRate) error {
+	dir := f.keyDir(key)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(rate)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, url.PathEscape(rate.Instance)+fileStoreExt))
+}
+
+// Rates reads the file of every instance that has published for key
+func (f *FileStore) Rates(ctx context.Context, key string) ([]InstanceRate, error) {
+	dir := f.keyDir(key)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ret := make([]InstanceRate, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), fileStoreExt) || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			if os.IsNotExist(err) {
+				// Removed after we listed the directory
+				continue
+			}
+			return nil, err
+		}
+		var r InstanceRate
+		if err := json.Unmarshal(b, &r); err != nil {
+			return nil, err
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+var _ Store = &FileStore{}
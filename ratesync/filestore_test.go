@@ -0,0 +1,82 @@
+package ratesync
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "ratesync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestFileStore(t *testing.T) {
+	dir := tempDir(t)
+	defer func() {
+		expectNilErr(t, os.RemoveAll(dir))
+	}()
+	testStore(t, &FileStore{Dir: filepath.Join(dir, "rates")})
+}
+
+func TestFileStore_infiniteRate(t *testing.T) {
+	dir := tempDir(t)
+	defer func() {
+		expectNilErr(t, os.RemoveAll(dir))
+	}()
+	store := &FileStore{Dir: dir}
+	// An InitialRate of zero is an unlimited rate
+	a := &Limiter{Store: store, Key: "host", Instance: "a"}
+	b := &Limiter{Store: store, Key: "host", Instance: "b", InitialRate: 30}
+	now := time.Now()
+	expectNilErr(t, a.Sync(context.Background(), now))
+	expectNilErr(t, b.Sync(context.Background(), now))
+	rates, err := store.Rates(context.Background(), "host")
+	expectNilErr(t, err)
+	if len(rates) != 2 {
+		t.Fatalf("expected both instances to publish, got %d", len(rates))
+	}
+	for _, r := range rates {
+		if r.Instance == "a" && !math.IsInf(r.Rate, 1) {
+			t.Errorf("expected an infinite rate to survive the store, got %f", r.Rate)
+		}
+		if r.Instance == "b" && r.Rate != 30 {
+			t.Errorf("expected a finite rate to survive the store, got %f", r.Rate)
+		}
+	}
+}
+
+func TestFileStore_ignoresOtherFiles(t *testing.T) {
+	dir := tempDir(t)
+	defer func() {
+		expectNilErr(t, os.RemoveAll(dir))
+	}()
+	s := &FileStore{Dir: dir}
+	expectNilErr(t, s.Publish(context.Background(), "host", InstanceRate{Instance: "a", Rate: 1, Updated: time.Now()}))
+	expectNilErr(t, ioutil.WriteFile(filepath.Join(dir, "host", ".tmp-123"), []byte("partial"), 0644))
+	expectNilErr(t, ioutil.WriteFile(filepath.Join(dir, "host", "README"), []byte("hello"), 0644))
+	if len(sortedRates(t, s, "host")) != 1 {
+		t.Error("expect only rate files to be read")
+	}
+}
+
+func TestFileStore_corrupt(t *testing.T) {
+	dir := tempDir(t)
+	defer func() {
+		expectNilErr(t, os.RemoveAll(dir))
+	}()
+	s := &FileStore{Dir: dir}
+	expectNilErr(t, os.MkdirAll(filepath.Join(dir, "host"), 0755))
+	expectNilErr(t, ioutil.WriteFile(filepath.Join(dir, "host", "a.json"), []byte("{"), 0644))
+	if _, err := s.Rates(context.Background(), "host"); err == nil {
+		t.Error("expect an error for a corrupt rate file")
+	}
+}
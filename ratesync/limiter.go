@@ -0,0 +1,263 @@
+package ratesync
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cep21/aimdcloser"
+	"golang.org/x/time/rate"
+)
+
+// Limiter is an AIMD rate limiter whose learned rate is shared between instances through a Store.  The rate it learns
+// is for every instance combined: each instance only allows its share, the learned rate divided by the number of
+// live instances.  This keeps many instances that reset together from each probing a recovering backend at
+// InitialRate.
+//
+// Limiter calls Sync in the background every SyncInterval, as long as it is being used.  Limiter is safe for
+// concurrent use.
+type Limiter struct {
+	// Store shares rates between instances
+	Store Store
+	// Key names what is rate limited, for example the backend's host.  Instances only share rates with the same Key.
+	Key string
+	// Instance uniquely names this instance.  Defaults to the hostname and process ID.
+	Instance string
+	// How many requests / sec are added to the combined rate when a success happens.
+	AdditiveIncrease float64
+	// What the combined rate is multiplied by on a failure.
+	MultiplicativeDecrease float64
+	// The combined rate of requests / sec to start at when reset.  Default of zero means infinite.
+	InitialRate float64
+	// Burst is how many requests this instance may burst to
+	Burst int
+	// SyncInterval is how often rates are exchanged with Store.  Defaults to 10 seconds.
+	SyncInterval time.Duration
+	// InstanceTTL is how recently an instance must have published to count as alive.  Defaults to three
+	// SyncInterval.
+	InstanceTTL time.Duration
+	// OnSyncError, if set, is called with errors of background syncs
+	OnSyncError func(err error)
+
+	// combined is the learned rate of every instance combined
+	combined float64
+	// instances is how many live instances the last Sync saw, including this one
+	instances int
+	lastSync  time.Time
+	syncing   bool
+	l         *rate.Limiter
+	mu        sync.Mutex
+}
+
+const defaultSyncInterval = time.Second * 10
+
+func (l *Limiter) init(now time.Time) {
+	if l.l == nil {
+		l.reset(now)
+	}
+	l.maybeSync(now)
+}
+
+// maybeSync starts a background Sync if one is due.  It must be called with the mutex held.
+func (l *Limiter) maybeSync(now time.Time) {
+	if l.syncing || now.Sub(l.lastSync) < l.syncInterval() {
+		return
+	}
+	l.syncing = true
+	l.lastSync = now
+	started := time.Now()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), l.syncInterval())
+		defer cancel()
+		// now is the caller's clock, so only move it forward by however long the goroutine took to start
+		err := l.Sync(ctx, now.Add(time.Since(started)))
+		if err != nil && l.OnSyncError != nil {
+			l.OnSyncError(err)
+		}
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.syncing = false
+	}()
+}
+
+func (l *Limiter) reset(now time.Time) {
+	l.combined = l.InitialRate
+	if l.combined == 0 {
+		l.combined = math.Inf(1)
+	}
+	if l.instances == 0 {
+		l.instances = 1
+	}
+	l.l = rate.NewLimiter(rate.Limit(l.share()), l.Burst)
+}
+
+// share is this instance's part of the combined rate
+func (l *Limiter) share() float64 {
+	return l.combined / float64(l.instances)
+}
+
+func (l *Limiter) setCombined(now time.Time, combined float64) {
+	l.combined = combined
+	l.l.SetLimitAt(now, rate.Limit(l.share()))
+}
+
+func (l *Limiter) syncInterval() time.Duration {
+	if l.SyncInterval <= 0 {
+		return defaultSyncInterval
+	}
+	return l.SyncInterval
+}
+
+func (l *Limiter) instanceTTL() time.Duration {
+	if l.InstanceTTL <= 0 {
+		return l.syncInterval() * 3
+	}
+	return l.InstanceTTL
+}
+
+func (l *Limiter) instance() string {
+	if l.Instance == "" {
+		hostname, _ := os.Hostname()
+		l.Instance = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	return l.Instance
+}
+
+// OnFailure multiplies the combined rate by MultiplicativeDecrease
+func (l *Limiter) OnFailure(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.init(now)
+	l.setCombined(now, l.combined*l.MultiplicativeDecrease)
+}
+
+// OnSuccess adds AdditiveIncrease to the combined rate
+func (l *Limiter) OnSuccess(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.init(now)
+	l.setCombined(now, l.combined+l.AdditiveIncrease)
+}
+
+// AttemptReserve tries to reserve a request inside this instance's share of the combined rate
+func (l *Limiter) AttemptReserve(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.init(now)
+	return l.l.AllowN(now, 1)
+}
+
+// Reset the combined rate back to InitialRate.  The number of live instances is remembered, so the reset share
+// is InitialRate divided between them.
+func (l *Limiter) Reset(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reset(now)
+}
+
+// Rate returns this instance's share of the combined rate
+func (l *Limiter) Rate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.l == nil {
+		if l.InitialRate == 0 {
+			return math.Inf(1)
+		}
+		return l.InitialRate / math.Max(1, float64(l.instances))
+	}
+	return l.share()
+}
+
+// MaxBurst returns Burst
+func (l *Limiter) MaxBurst() int {
+	return l.Burst
+}
+
+// Instances returns how many live instances the last Sync saw, including this one
+func (l *Limiter) Instances() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.instances == 0 {
+		return 1
+	}
+	return l.instances
+}
+
+// Sync publishes this instance's combined rate to Store, then reads the rates of every live instance.  The combined
+// rate becomes the average of every live instance's finite combined rate, and is divided by the number of live
+// instances.  Instances that are unlimited, for example because they just reset without an InitialRate, count as
+// live but do not make everybody unlimited.  Liveness is judged at now plus however long Store took to answer.
+func (l *Limiter) Sync(ctx context.Context, now time.Time) error {
+	started := time.Now()
+	l.mu.Lock()
+	if l.l == nil {
+		l.reset(now)
+	}
+	l.lastSync = now
+	mine := InstanceRate{
+		Instance: l.instance(),
+		Rate:     l.combined,
+		Updated:  now,
+	}
+	l.mu.Unlock()
+	if err := l.Store.Publish(ctx, l.Key, mine); err != nil {
+		return err
+	}
+	rates, err := l.Store.Rates(ctx, l.Key)
+	if err != nil {
+		return err
+	}
+	now = now.Add(time.Since(started))
+	sum, finite := 0.0, 0
+	if !math.IsInf(mine.Rate, 0) && !math.IsNaN(mine.Rate) {
+		sum += mine.Rate
+		finite++
+	}
+	count := 1
+	for _, r := range rates {
+		if r.Instance == mine.Instance || now.Sub(r.Updated) >= l.instanceTTL() {
+			continue
+		}
+		count++
+		if math.IsInf(r.Rate, 0) || math.IsNaN(r.Rate) {
+			continue
+		}
+		sum += r.Rate
+		finite++
+	}
+	average := math.Inf(1)
+	if finite > 0 {
+		average = sum / float64(finite)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.instances = count
+	// Keep any change to our rate that happened while we talked to Store
+	changed := l.combined - mine.Rate
+	if math.IsNaN(changed) {
+		changed = 0
+	}
+	l.setCombined(now, average+changed)
+	return nil
+}
+
+// LimiterConstructor constructs limiters that share rates for key through store.  See documentation for Limiter for
+// what each parameter means.
+func LimiterConstructor(store Store, key string, additiveIncrease float64, multiplicativeDecrease float64, initialRate float64, burst int) func() aimdcloser.RateLimiter {
+	return func() aimdcloser.RateLimiter {
+		return &Limiter{
+			Store:                  store,
+			Key:                    key,
+			AdditiveIncrease:       additiveIncrease,
+			MultiplicativeDecrease: multiplicativeDecrease,
+			InitialRate:            initialRate,
+			Burst:                  burst,
+		}
+	}
+}
+
+var _ aimdcloser.RateLimiter = &Limiter{}
+var _ aimdcloser.RateReporter = &Limiter{}
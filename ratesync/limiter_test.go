@@ -0,0 +1,229 @@
+package ratesync
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cep21/aimdcloser/ratecloser"
+)
+
+func equalFloat(t *testing.T, expected float64, given float64) {
+	t.Helper()
+	if math.Abs(expected-given) >= .00001 {
+		t.Errorf("Unexpected value.  Expected %f Given %f", expected, given)
+	}
+}
+
+func instances(store Store, names ...string) []*Limiter {
+	ret := make([]*Limiter, 0, len(names))
+	for _, name := range names {
+		ret = append(ret, &Limiter{
+			Store:                  store,
+			Key:                    "host",
+			Instance:               name,
+			AdditiveIncrease:       1,
+			MultiplicativeDecrease: .5,
+			InitialRate:            30,
+			Burst:                  1,
+		})
+	}
+	return ret
+}
+
+func syncAll(t *testing.T, now time.Time, limiters []*Limiter) {
+	t.Helper()
+	for _, l := range limiters {
+		expectNilErr(t, l.Sync(context.Background(), now))
+	}
+}
+
+func TestLimiter_share(t *testing.T) {
+	limiters := instances(&MemoryStore{}, "a", "b", "c")
+	now := time.Now()
+	syncAll(t, now, limiters)
+	// The last instance to sync is the first to see everybody
+	if limiters[2].Instances() != 3 {
+		t.Errorf("expect three live instances, got %d", limiters[2].Instances())
+	}
+	syncAll(t, now, limiters)
+	for _, l := range limiters {
+		l.Reset(now)
+		equalFloat(t, 10, l.Rate())
+	}
+	if !limiters[0].AttemptReserve(now) {
+		t.Error("expect the first request to be allowed")
+	}
+	if limiters[0].AttemptReserve(now.Add(time.Second / 20)) {
+		t.Error("expect an instance to be limited to its share")
+	}
+	if !limiters[0].AttemptReserve(now.Add(time.Second / 10)) {
+		t.Error("expect an instance to be allowed its share")
+	}
+}
+
+func TestLimiter_average(t *testing.T) {
+	limiters := instances(&MemoryStore{}, "a", "b")
+	now := time.Now()
+	syncAll(t, now, limiters)
+	syncAll(t, now, limiters)
+	limiters[0].OnFailure(now)
+	limiters[1].OnSuccess(now)
+	equalFloat(t, 7.5, limiters[0].Rate())
+	equalFloat(t, 15.5, limiters[1].Rate())
+	// Each sync averages with what the other instance last published, so instances converge
+	for i := 0; i < 20; i++ {
+		syncAll(t, now, limiters)
+	}
+	equalFloat(t, limiters[0].Rate(), limiters[1].Rate())
+	if limiters[0].Rate() <= 7.5 || limiters[0].Rate() >= 15.5 {
+		t.Errorf("expect instances to converge between their rates, got %f", limiters[0].Rate())
+	}
+}
+
+func TestLimiter_unlimitedInstance(t *testing.T) {
+	store := &MemoryStore{}
+	limiters := instances(store, "a")
+	now := time.Now()
+	expectNilErr(t, store.Publish(context.Background(), "host", InstanceRate{
+		Instance: "b",
+		Rate:     math.Inf(1),
+		Updated:  now,
+	}))
+	syncAll(t, now, limiters)
+	if limiters[0].Instances() != 2 {
+		t.Errorf("expect an unlimited instance to be live, got %d", limiters[0].Instances())
+	}
+	equalFloat(t, 15, limiters[0].Rate())
+	// An instance that is unlimited itself takes the average of the others
+	limiters[0].InitialRate = 0
+	limiters[0].Reset(now)
+	expectNilErr(t, store.Publish(context.Background(), "host", InstanceRate{
+		Instance: "c",
+		Rate:     20,
+		Updated:  now,
+	}))
+	syncAll(t, now, limiters)
+	equalFloat(t, 20.0/3, limiters[0].Rate())
+}
+
+func TestLimiter_deadInstances(t *testing.T) {
+	limiters := instances(&MemoryStore{}, "a", "b")
+	limiters[0].SyncInterval = time.Second
+	now := time.Now()
+	syncAll(t, now, limiters)
+	expectNilErr(t, limiters[0].Sync(context.Background(), now))
+	if limiters[0].Instances() != 2 {
+		t.Fatal("expect both instances to be live")
+	}
+	expectNilErr(t, limiters[0].Sync(context.Background(), now.Add(time.Second*3)))
+	if limiters[0].Instances() != 1 {
+		t.Error("expect an instance that stopped publishing to be dead")
+	}
+}
+
+type errStore struct {
+	MemoryStore
+	publishErr error
+	ratesErr   error
+	errMu      sync.Mutex
+}
+
+func (e *errStore) setErrs(publishErr error, ratesErr error) {
+	e.errMu.Lock()
+	defer e.errMu.Unlock()
+	e.publishErr = publishErr
+	e.ratesErr = ratesErr
+}
+
+func (e *errStore) Publish(ctx context.Context, key string, rate InstanceRate) error {
+	e.errMu.Lock()
+	err := e.publishErr
+	e.errMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return e.MemoryStore.Publish(ctx, key, rate)
+}
+
+func (e *errStore) Rates(ctx context.Context, key string) ([]InstanceRate, error) {
+	e.errMu.Lock()
+	err := e.ratesErr
+	e.errMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return e.MemoryStore.Rates(ctx, key)
+}
+
+func TestLimiter_Sync_errors(t *testing.T) {
+	s := &errStore{}
+	l := instances(s, "a")[0]
+	publishErr := errors.New("bad publish")
+	s.setErrs(publishErr, nil)
+	if l.Sync(context.Background(), time.Now()) != publishErr {
+		t.Error("expect publish errors")
+	}
+	ratesErr := errors.New("bad rates")
+	s.setErrs(nil, ratesErr)
+	if l.Sync(context.Background(), time.Now()) != ratesErr {
+		t.Error("expect rates errors")
+	}
+}
+
+func TestLimiter_backgroundSync(t *testing.T) {
+	s := &errStore{}
+	l := instances(s, "a")[0]
+	l.SyncInterval = time.Millisecond
+	errs := make(chan error, 10)
+	l.OnSyncError = func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+	l.AttemptReserve(time.Now())
+	for start := time.Now(); len(sortedRates(t, s, "host")) == 0; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("expect use of the limiter to sync in the background")
+		}
+	}
+	s.setErrs(errors.New("bad publish"), nil)
+	for start := time.Now(); len(errs) == 0; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("expect background sync errors to be reported")
+		}
+		l.OnSuccess(time.Now())
+	}
+}
+
+func TestLimiter_defaults(t *testing.T) {
+	l := &Limiter{Store: &MemoryStore{}}
+	if !math.IsInf(l.Rate(), 1) {
+		t.Error("expect an infinite rate by default")
+	}
+	if l.instance() == "" {
+		t.Error("expect a default instance name")
+	}
+	if l.instanceTTL() != defaultSyncInterval*3 {
+		t.Error("expect the default TTL to be three sync intervals")
+	}
+	expectNilErr(t, l.Sync(context.Background(), time.Now()))
+	if !math.IsInf(l.Rate(), 1) {
+		t.Error("expect an infinite rate to stay infinite after a sync")
+	}
+}
+
+func TestLimiterConstructor(t *testing.T) {
+	store := &MemoryStore{}
+	closer := ratecloser.CloserFactory(ratecloser.CloserConfig{
+		RateLimiter: LimiterConstructor(store, "host", 1, .5, 10, 2),
+	})().(*ratecloser.Closer)
+	l := closer.Rater.(*Limiter)
+	if l.Store != store || l.Key != "host" || l.InitialRate != 10 || l.MaxBurst() != 2 {
+		t.Error("expect constructor to set every parameter")
+	}
+}
@@ -0,0 +1,99 @@
+package ratesync
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+)
+
+// InstanceRate is the rate a single instance has learned for a key.
+type InstanceRate struct {
+	// Instance uniquely names the instance, for example a hostname and process ID
+	Instance string
+	// Rate is the aggregate requests / sec the instance has learned for every instance combined
+	Rate float64
+	// Updated is when the instance published Rate
+	Updated time.Time
+}
+
+// infiniteRate is how an unlimited Rate is written to JSON, which has no number for infinity
+const infiniteRate = "+Inf"
+
+type plainInstanceRate InstanceRate
+
+// MarshalJSON writes Rate as a number, or as the string "+Inf" when it is unlimited
+func (r InstanceRate) MarshalJSON() ([]byte, error) {
+	aux := struct {
+		plainInstanceRate
+		Rate interface{}
+	}{plainInstanceRate: plainInstanceRate(r), Rate: r.Rate}
+	if math.IsInf(r.Rate, 1) {
+		aux.Rate = infiniteRate
+	}
+	return json.Marshal(aux)
+}
+
+// UnmarshalJSON reads what MarshalJSON writes
+func (r *InstanceRate) UnmarshalJSON(b []byte) error {
+	aux := struct {
+		*plainInstanceRate
+		Rate json.RawMessage
+	}{plainInstanceRate: (*plainInstanceRate)(r)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	var s string
+	if json.Unmarshal(aux.Rate, &s) == nil && s == infiniteRate {
+		r.Rate = math.Inf(1)
+		return nil
+	}
+	if len(aux.Rate) == 0 {
+		return nil
+	}
+	return json.Unmarshal(aux.Rate, &r.Rate)
+}
+
+// Store publishes the rates instances have learned and reads the rates of other instances.  Implementations must be
+// safe for concurrent use.
+type Store interface {
+	// Publish records the rate of rate.Instance for key, replacing what that instance published before.
+	Publish(ctx context.Context, key string, rate InstanceRate) error
+	// Rates returns the most recent rate of every instance that has published for key.  It may include instances
+	// that are no longer alive.  Callers decide which ones are recent enough to use.
+	Rates(ctx context.Context, key string) ([]InstanceRate, error)
+}
+
+// MemoryStore is a Store inside a single process, useful for tests.  The zero value is ready to use.
+type MemoryStore struct {
+	rates map[string]map[string]InstanceRate
+	mu    sync.Mutex
+}
+
+// Publish stores rate for key
+func (m *MemoryStore) Publish(ctx context.Context, key string, rate InstanceRate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.rates == nil {
+		m.rates = make(map[string]map[string]InstanceRate)
+	}
+	if m.rates[key] == nil {
+		m.rates[key] = make(map[string]InstanceRate)
+	}
+	m.rates[key][rate.Instance] = rate
+	return nil
+}
+
+// Rates returns every rate stored for key
+func (m *MemoryStore) Rates(ctx context.Context, key string) ([]InstanceRate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ret := make([]InstanceRate, 0, len(m.rates[key]))
+	for _, r := range m.rates[key] {
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+var _ Store = &MemoryStore{}
@@ -0,0 +1,58 @@
+package ratesync
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+)
+
+func sortedRates(t *testing.T, s Store, key string) []InstanceRate {
+	t.Helper()
+	rates, err := s.Rates(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(rates, func(i, j int) bool {
+		return rates[i].Instance < rates[j].Instance
+	})
+	return rates
+}
+
+// testStore runs the behavior every Store should have
+func testStore(t *testing.T, s Store) {
+	ctx := context.Background()
+	now := time.Now().Round(0)
+	if len(sortedRates(t, s, "missing")) != 0 {
+		t.Error("expect no rates for a new key")
+	}
+	expectNilErr(t, s.Publish(ctx, "host", InstanceRate{Instance: "a", Rate: 1, Updated: now}))
+	expectNilErr(t, s.Publish(ctx, "host", InstanceRate{Instance: "b/weird name", Rate: 2, Updated: now}))
+	expectNilErr(t, s.Publish(ctx, "host", InstanceRate{Instance: "a", Rate: 3, Updated: now.Add(time.Second)}))
+	expectNilErr(t, s.Publish(ctx, "other/host", InstanceRate{Instance: "a", Rate: 4, Updated: now}))
+	rates := sortedRates(t, s, "host")
+	if len(rates) != 2 {
+		t.Fatalf("expect one rate per instance, got %v", rates)
+	}
+	if rates[0].Instance != "a" || rates[0].Rate != 3 || !rates[0].Updated.Equal(now.Add(time.Second)) {
+		t.Errorf("expect the latest rate of an instance, got %v", rates[0])
+	}
+	if rates[1].Instance != "b/weird name" || rates[1].Rate != 2 {
+		t.Errorf("unexpected rate %v", rates[1])
+	}
+	rates = sortedRates(t, s, "other/host")
+	if len(rates) != 1 || rates[0].Rate != 4 {
+		t.Errorf("expect keys to be separate, got %v", rates)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, &MemoryStore{})
+}
+
+func expectNilErr(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Error(err.Error())
+	}
+}
@@ -0,0 +1,223 @@
+package aimdcloser
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Sharded is an AIMD split into shards, each with its own lock, so many goroutines can use it at once without
+// contending on one mutex.  Each shard holds part of the learned rate and burst.  A success adds AdditiveIncrease to
+// one shard, and a failure multiplies every shard by MultiplicativeDecrease, so the combined rate follows AIMD.
+// Shard rates are evened out every RebalanceInterval, since successes land on shards unevenly.
+//
+// Goroutines mostly keep using the shard of the processor (P) they run on.  Traffic that is very uneven across
+// processors may be rejected by its shard while other shards have room.  Sharded is safe for concurrent use.
+type Sharded struct {
+	// How many requests / sec are added to the combined rate when a success happens
+	AdditiveIncrease float64
+	// What the combined rate is multiplied by on a failure
+	MultiplicativeDecrease float64
+	// The combined rate of requests / sec to start at when reset
+	InitialRate float64
+	// Burst is the combined burst.  It is split between the shards, so they add up to exactly Burst.
+	Burst int
+	// Shards is how many shards the rate is split into.  Defaults to GOMAXPROCS.  There are never more shards than a
+	// positive Burst, so every shard can make a request.
+	Shards int
+	// RebalanceInterval is how often shard rates are evened out.  Defaults to one second.
+	RebalanceInterval time.Duration
+
+	once   sync.Once
+	shards []shardedShard
+	// pool hands out shard indexes, and keeps them per P
+	pool     sync.Pool
+	nextPool uint32
+	// failures counts every failure.  Shards apply the ones they have not seen the next time they are used.
+	failures      uint64
+	lastRebalance int64
+}
+
+type shardedShard struct {
+	mu           sync.Mutex
+	aimd         AIMD
+	seenFailures uint64
+	// keep shards on separate cache lines
+	_ [64]byte
+}
+
+const defaultRebalanceInterval = time.Second
+
+// ShardedConstructor constructs rate limiters according to the given parameters.  See documentation for Sharded for
+// what each parameter means.
+func ShardedConstructor(additiveIncrease float64, multiplicativeDecrease float64, initialRate float64, burst int) func() RateLimiter {
+	return func() RateLimiter {
+		return &Sharded{
+			AdditiveIncrease:       additiveIncrease,
+			MultiplicativeDecrease: multiplicativeDecrease,
+			InitialRate:            initialRate,
+			Burst:                  burst,
+		}
+	}
+}
+
+func (s *Sharded) init() {
+	s.once.Do(func() {
+		n := s.Shards
+		if n <= 0 {
+			n = runtime.GOMAXPROCS(0)
+		}
+		if s.Burst > 0 && n > s.Burst {
+			n = s.Burst
+		}
+		s.shards = make([]shardedShard, n)
+		for i := range s.shards {
+			burst := s.Burst / n
+			if i < s.Burst%n {
+				burst++
+			}
+			s.shards[i].aimd = AIMD{
+				AdditiveIncrease:       s.AdditiveIncrease,
+				MultiplicativeDecrease: s.MultiplicativeDecrease,
+				InitialRate:            s.InitialRate / float64(n),
+				Burst:                  burst,
+			}
+		}
+		s.pool.New = func() interface{} {
+			idx := int(atomic.AddUint32(&s.nextPool, 1)-1) % n
+			return &idx
+		}
+	})
+}
+
+// lock returns the locked shard of the calling goroutine, with every failure applied.  Callers must unlock it.
+func (s *Sharded) lock(now time.Time) *shardedShard {
+	s.init()
+	s.maybeRebalance(now)
+	idx := s.pool.Get().(*int)
+	sh := &s.shards[*idx]
+	s.pool.Put(idx)
+	sh.mu.Lock()
+	s.catchUp(sh, now)
+	return sh
+}
+
+// catchUp applies failures the shard has not seen.  It must be called with the shard's lock held.
+func (s *Sharded) catchUp(sh *shardedShard, now time.Time) {
+	failures := atomic.LoadUint64(&s.failures)
+	if pending := failures - sh.seenFailures; pending > 0 {
+		sh.aimd.OnBatch(now, Batch{Failures: int(pending)})
+	}
+	sh.seenFailures = failures
+}
+
+func (s *Sharded) rebalanceInterval() time.Duration {
+	if s.RebalanceInterval <= 0 {
+		return defaultRebalanceInterval
+	}
+	return s.RebalanceInterval
+}
+
+func (s *Sharded) maybeRebalance(now time.Time) {
+	last := atomic.LoadInt64(&s.lastRebalance)
+	if now.UnixNano()-last < int64(s.rebalanceInterval()) {
+		return
+	}
+	if atomic.CompareAndSwapInt64(&s.lastRebalance, last, now.UnixNano()) {
+		s.Rebalance(now)
+	}
+}
+
+// lockAll locks every shard, in order, with every failure applied.  Callers must unlock them with unlockAll.
+func (s *Sharded) lockAll(now time.Time) {
+	s.init()
+	for i := range s.shards {
+		s.shards[i].mu.Lock()
+		s.catchUp(&s.shards[i], now)
+	}
+}
+
+func (s *Sharded) unlockAll() {
+	for i := range s.shards {
+		s.shards[i].mu.Unlock()
+	}
+}
+
+// Rebalance evens out the rate of every shard, keeping the combined rate.  It happens on its own every
+// RebalanceInterval.
+func (s *Sharded) Rebalance(now time.Time) {
+	s.lockAll(now)
+	defer s.unlockAll()
+	total := 0.0
+	for i := range s.shards {
+		s.shards[i].aimd.init(now)
+		total += float64(s.shards[i].aimd.l.Limit())
+	}
+	share := total / float64(len(s.shards))
+	for i := range s.shards {
+		s.shards[i].aimd.l.SetLimitAt(now, rate.Limit(share))
+	}
+}
+
+// OnSuccess adds AdditiveIncrease to the combined rate, through the calling goroutine's shard
+func (s *Sharded) OnSuccess(now time.Time) {
+	sh := s.lock(now)
+	defer sh.mu.Unlock()
+	sh.aimd.OnSuccess(now)
+}
+
+// OnFailure multiplies the combined rate by MultiplicativeDecrease.  Shards apply it the next time they are used.
+func (s *Sharded) OnFailure(now time.Time) {
+	s.init()
+	atomic.AddUint64(&s.failures, 1)
+}
+
+// AttemptReserve tries to reserve a request from the calling goroutine's shard
+func (s *Sharded) AttemptReserve(now time.Time) bool {
+	sh := s.lock(now)
+	defer sh.mu.Unlock()
+	return sh.aimd.AttemptReserve(now)
+}
+
+// Reset every shard back to its part of InitialRate
+func (s *Sharded) Reset(now time.Time) {
+	s.lockAll(now)
+	defer s.unlockAll()
+	for i := range s.shards {
+		s.shards[i].aimd.Reset(now)
+	}
+}
+
+// Rate returns the combined rate of every shard
+func (s *Sharded) Rate() float64 {
+	s.init()
+	ret := 0.0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		r := sh.aimd.Rate()
+		if pending := atomic.LoadUint64(&s.failures) - sh.seenFailures; pending > 0 {
+			r *= math.Pow(s.MultiplicativeDecrease, float64(pending))
+		}
+		sh.mu.Unlock()
+		ret += r
+	}
+	return ret
+}
+
+// MaxBurst returns the combined burst of every shard
+func (s *Sharded) MaxBurst() int {
+	s.init()
+	ret := 0
+	for i := range s.shards {
+		ret += s.shards[i].aimd.Burst
+	}
+	return ret
+}
+
+var _ RateLimiter = &Sharded{}
+var _ RateReporter = &Sharded{}
@@ -0,0 +1,129 @@
+package aimdcloser
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSharded_followsAIMD(t *testing.T) {
+	now := time.Now()
+	s := &Sharded{AdditiveIncrease: 1, MultiplicativeDecrease: .5, InitialRate: 100, Burst: 10, Shards: 4}
+	a := &AIMD{AdditiveIncrease: 1, MultiplicativeDecrease: .5, InitialRate: 100, Burst: 10}
+	equalFloat(t, 100, s.Rate())
+	for i := 0; i < 10; i++ {
+		s.OnSuccess(now)
+		a.OnSuccess(now)
+	}
+	equalFloat(t, a.Rate(), s.Rate())
+	s.OnFailure(now)
+	a.OnFailure(now)
+	equalFloat(t, a.Rate(), s.Rate())
+	s.OnFailure(now)
+	s.OnSuccess(now)
+	a.OnFailure(now)
+	a.OnSuccess(now)
+	equalFloat(t, a.Rate(), s.Rate())
+	s.Reset(now)
+	equalFloat(t, 100, s.Rate())
+	// Failures from before the reset are not applied again
+	s.OnSuccess(now)
+	equalFloat(t, 101, s.Rate())
+}
+
+func TestSharded_Burst(t *testing.T) {
+	s := &Sharded{InitialRate: 1, Burst: 10, Shards: 4}
+	equalInt(t, 10, s.MaxBurst())
+	now := time.Now()
+	admitted := 0
+	for i := 0; i < 100; i++ {
+		if s.AttemptReserve(now) {
+			admitted++
+		}
+	}
+	expect(t, admitted >= 2 && admitted <= 10, "expected at most the combined burst, got "+strconv.Itoa(admitted))
+}
+
+func TestSharded_MoreShardsThanBurst(t *testing.T) {
+	s := &Sharded{InitialRate: 1, Burst: 10, Shards: 64}
+	equalInt(t, 10, s.MaxBurst())
+	equalInt(t, 10, len(s.shards))
+	equalFloat(t, 1, s.Rate())
+	now := time.Now()
+	admitted := 0
+	for i := range s.shards {
+		for s.shards[i].aimd.AttemptReserve(now) {
+			admitted++
+		}
+	}
+	equalInt(t, 10, admitted)
+}
+
+func TestSharded_Rebalance(t *testing.T) {
+	now := time.Now()
+	s := &Sharded{AdditiveIncrease: 1, MultiplicativeDecrease: .5, InitialRate: 40, Burst: 4, Shards: 4, RebalanceInterval: time.Hour}
+	s.init()
+	s.lastRebalance = now.UnixNano()
+	for i := 0; i < 20; i++ {
+		s.OnSuccess(now)
+	}
+	s.Rebalance(now)
+	equalFloat(t, 60, s.Rate())
+	for i := range s.shards {
+		equalFloat(t, 15, s.shards[i].aimd.Rate())
+	}
+	// Rebalancing happens on its own once the interval passes
+	s.OnSuccess(now)
+	s.AttemptReserve(now.Add(time.Hour * 2))
+	for i := range s.shards {
+		equalFloat(t, 61.0/4, s.shards[i].aimd.Rate())
+	}
+}
+
+func TestSharded_concurrent(t *testing.T) {
+	s := &Sharded{AdditiveIncrease: 1, MultiplicativeDecrease: .5, InitialRate: 1000, Burst: 100, RebalanceInterval: time.Millisecond}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				now := time.Now()
+				if s.AttemptReserve(now) {
+					s.OnSuccess(now)
+				}
+				if j%100 == i {
+					s.OnFailure(now)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	expect(t, s.Rate() > 0, "expected a positive rate")
+}
+
+func BenchmarkSharded_AttemptReserveParallel(b *testing.B) {
+	for _, procs := range []int{1, 4, 16, 64} {
+		b.Run("procs="+strconv.Itoa(procs), func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+			benchmarkParallelReserve(b, &SyncRateLimiter{RateLimiter: &AIMD{AdditiveIncrease: 1, MultiplicativeDecrease: .5, InitialRate: 1e9, Burst: 1e6}}, "mutex")
+			benchmarkParallelReserve(b, &Sharded{AdditiveIncrease: 1, MultiplicativeDecrease: .5, InitialRate: 1e9, Burst: 1e6}, "sharded")
+		})
+	}
+}
+
+func benchmarkParallelReserve(b *testing.B, r RateLimiter, name string) {
+	b.Run(name, func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				now := time.Now()
+				if r.AttemptReserve(now) {
+					r.OnSuccess(now)
+				}
+			}
+		})
+	})
+}
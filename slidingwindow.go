@@ -0,0 +1,190 @@
+package aimdcloser
+
+import (
+	"time"
+)
+
+// SlidingWindow limits requests to Limit per rolling Window, like the quotas of many backends, learning the limit
+// like AIMD.  The window is split into Buckets buckets that count requests.  The oldest bucket, which is partly
+// outside of the window, is counted in proportion to how much of it is still inside.
+//
+// The limit never drops below one request per window.
+// It is *NOT* thread safe
+type SlidingWindow struct {
+	// How many requests per window are added to the limit when a success happens
+	AdditiveIncrease float64
+	// What the limit is multiplied by on a failure
+	MultiplicativeDecrease float64
+	// InitialLimit is how many requests are allowed per window when reset
+	InitialLimit float64
+	// Window is how long requests are counted for.  Defaults to a minute.
+	Window time.Duration
+	// Buckets is how many parts the window is split into.  More buckets slide more smoothly.  Defaults to 10.
+	Buckets int
+
+	limit float64
+	// counts is a ring of Buckets+1 buckets, so the bucket partly outside of the window is kept
+	counts []int64
+	// last is the most recent bucket counted into, as the number of buckets since the unix epoch
+	last        int64
+	pausedUntil time.Time
+}
+
+const (
+	defaultSlidingWindow        = time.Minute
+	defaultSlidingWindowBuckets = 10
+)
+
+// SlidingWindowConstructor constructs rate limiters according to the given parameters.  See documentation for
+// SlidingWindow for what each parameter means.
+func SlidingWindowConstructor(additiveIncrease float64, multiplicativeDecrease float64, initialLimit float64, window time.Duration, buckets int) func() RateLimiter {
+	return func() RateLimiter {
+		return &SlidingWindow{
+			AdditiveIncrease:       additiveIncrease,
+			MultiplicativeDecrease: multiplicativeDecrease,
+			InitialLimit:           initialLimit,
+			Window:                 window,
+			Buckets:                buckets,
+		}
+	}
+}
+
+func (s *SlidingWindow) window() time.Duration {
+	if s.Window <= 0 {
+		return defaultSlidingWindow
+	}
+	return s.Window
+}
+
+func (s *SlidingWindow) buckets() int {
+	if s.Buckets <= 0 {
+		return defaultSlidingWindowBuckets
+	}
+	return s.Buckets
+}
+
+func (s *SlidingWindow) bucketLength() int64 {
+	l := int64(s.window()) / int64(s.buckets())
+	if l <= 0 {
+		return 1
+	}
+	return l
+}
+
+// Reset the RateLimiter back to the initial limit, forgetting every request.  Like AIMD, a pause that has not ended
+// is kept.
+func (s *SlidingWindow) Reset(now time.Time) {
+	s.counts = make([]int64, s.buckets()+1)
+	s.last = now.UnixNano() / s.bucketLength()
+	if !now.Before(s.pausedUntil) {
+		s.pausedUntil = time.Time{}
+	}
+	s.setLimit(s.InitialLimit)
+}
+
+func (s *SlidingWindow) init(now time.Time) {
+	if s.counts == nil {
+		s.Reset(now)
+	}
+}
+
+func (s *SlidingWindow) setLimit(limit float64) {
+	s.limit = windowLimit(limit)
+}
+
+// windowLimit keeps a limit at one request per window or more
+func windowLimit(limit float64) float64 {
+	if limit < 1 {
+		return 1
+	}
+	return limit
+}
+
+// advance clears buckets that have left the window since the last request
+func (s *SlidingWindow) advance(bucket int64) {
+	if bucket <= s.last {
+		return
+	}
+	n := int64(len(s.counts))
+	stale := bucket - s.last
+	if stale > n {
+		stale = n
+	}
+	for i := int64(1); i <= stale; i++ {
+		s.counts[(s.last+i)%n] = 0
+	}
+	s.last = bucket
+}
+
+// count returns how many requests are in the window ending at now
+func (s *SlidingWindow) count(now time.Time) float64 {
+	length := s.bucketLength()
+	bucket := now.UnixNano() / length
+	s.advance(bucket)
+	n := int64(len(s.counts))
+	ret := 0.0
+	for i := int64(0); i < n-1; i++ {
+		ret += float64(s.counts[(s.last-i)%n])
+	}
+	inside := 1 - float64(now.UnixNano()%length)/float64(length)
+	return ret + float64(s.counts[(s.last+1)%n])*inside
+}
+
+// AttemptReserve tries to reserve a request in the window ending at now.  Returns if the rate limiter allows you to
+// reserve a request.
+func (s *SlidingWindow) AttemptReserve(now time.Time) bool {
+	s.init(now)
+	if now.Before(s.pausedUntil) {
+		return false
+	}
+	if s.count(now)+1 > s.limit {
+		return false
+	}
+	s.counts[s.last%int64(len(s.counts))]++
+	return true
+}
+
+// OnSuccess increases the limit by AdditiveIncrease
+func (s *SlidingWindow) OnSuccess(now time.Time) {
+	s.init(now)
+	s.setLimit(s.limit + s.AdditiveIncrease)
+}
+
+// OnFailure multiplies the limit by MultiplicativeDecrease
+func (s *SlidingWindow) OnFailure(now time.Time) {
+	s.init(now)
+	s.setLimit(s.limit * s.MultiplicativeDecrease)
+}
+
+// OnHint jumps to the limit of the hinted rate, and denies every request until the hinted pause is over
+func (s *SlidingWindow) OnHint(now time.Time, hint Hint) {
+	s.init(now)
+	if hint.Rate > 0 {
+		s.setLimit(hint.Rate * s.window().Seconds())
+	}
+	if hint.PauseUntil.After(s.pausedUntil) {
+		s.pausedUntil = hint.PauseUntil
+	}
+}
+
+// Limit returns how many requests are allowed per window
+func (s *SlidingWindow) Limit() float64 {
+	if s.counts == nil {
+		return windowLimit(s.InitialLimit)
+	}
+	return s.limit
+}
+
+// Rate returns the limit as requests / sec
+func (s *SlidingWindow) Rate() float64 {
+	return s.Limit() / s.window().Seconds()
+}
+
+// MaxBurst returns the limit, since every request of a window may be made at once
+func (s *SlidingWindow) MaxBurst() int {
+	return int(s.Limit())
+}
+
+var _ RateLimiter = &SlidingWindow{}
+var _ RateReporter = &SlidingWindow{}
+var _ RateHinter = &SlidingWindow{}
@@ -0,0 +1,83 @@
+package aimdcloser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlidingWindowConstructor(t *testing.T) {
+	r := SlidingWindowConstructor(1, .5, 60, time.Minute, 6)().(*SlidingWindow)
+	equalFloat(t, 1, r.AdditiveIncrease)
+	equalFloat(t, .5, r.MultiplicativeDecrease)
+	equalFloat(t, 60, r.Limit())
+	equalFloat(t, 1, r.Rate())
+	equalInt(t, 60, r.MaxBurst())
+	equalInt(t, 6, r.Buckets)
+}
+
+func TestSlidingWindow_zeroInitialLimit(t *testing.T) {
+	s := &SlidingWindow{Window: time.Second}
+	equalFloat(t, 1, s.Limit())
+	equalFloat(t, 1, s.Rate())
+	equalInt(t, 1, s.MaxBurst())
+	now := time.Unix(1000, 0)
+	expect(t, s.AttemptReserve(now), "expected one request per window")
+	expect(t, !s.AttemptReserve(now), "expected no more than one request per window")
+	equalFloat(t, 1, s.Limit())
+}
+
+func TestSlidingWindow_slides(t *testing.T) {
+	s := &SlidingWindow{InitialLimit: 10, Window: time.Second, Buckets: 10}
+	now := time.Unix(1000, 0)
+	for i := 0; i < 10; i++ {
+		expect(t, s.AttemptReserve(now), "expected the whole limit at once")
+	}
+	expect(t, !s.AttemptReserve(now), "expected no more than the limit")
+	expect(t, !s.AttemptReserve(now.Add(time.Millisecond*999)), "expected the requests to still be in the window")
+	// The bucket of the first requests is halfway out of the window
+	now = now.Add(time.Millisecond * 1050)
+	for i := 0; i < 5; i++ {
+		expect(t, s.AttemptReserve(now), "expected the part of the window that slid out to be free")
+	}
+	expect(t, !s.AttemptReserve(now), "expected the rest of the window to be counted")
+	// Long after, every bucket is empty
+	now = now.Add(time.Hour)
+	for i := 0; i < 10; i++ {
+		expect(t, s.AttemptReserve(now), "expected an empty window")
+	}
+}
+
+func TestSlidingWindow_spreadOverBuckets(t *testing.T) {
+	s := &SlidingWindow{InitialLimit: 4, Window: time.Second, Buckets: 4}
+	now := time.Unix(1000, 0)
+	for i := 0; i < 4; i++ {
+		expect(t, s.AttemptReserve(now.Add(time.Millisecond*250*time.Duration(i))), "expected a request per bucket")
+	}
+	expect(t, !s.AttemptReserve(now.Add(time.Millisecond*999)), "expected the window to be full")
+	expect(t, !s.AttemptReserve(now.Add(time.Second)), "expected the first bucket to be counted until it starts leaving")
+	expect(t, s.AttemptReserve(now.Add(time.Millisecond*1250)), "expected the first bucket to have left the window")
+	expect(t, !s.AttemptReserve(now.Add(time.Millisecond*1250)), "expected only the first bucket to have left the window")
+}
+
+func TestSlidingWindow_feedback(t *testing.T) {
+	s := &SlidingWindow{AdditiveIncrease: 2, MultiplicativeDecrease: .5, InitialLimit: 10, Window: time.Second * 10}
+	now := time.Now()
+	s.OnSuccess(now)
+	equalFloat(t, 12, s.Limit())
+	equalFloat(t, 1.2, s.Rate())
+	s.OnFailure(now)
+	equalFloat(t, 6, s.Limit())
+	for i := 0; i < 5; i++ {
+		s.OnFailure(now)
+	}
+	equalFloat(t, 1, s.Limit())
+	expect(t, s.AttemptReserve(now), "expected at least a request per window")
+
+	s.OnHint(now, Hint{Rate: 3, PauseUntil: now.Add(time.Hour)})
+	equalFloat(t, 30, s.Limit())
+	expect(t, !s.AttemptReserve(now.Add(time.Minute)), "expected no requests during a pause")
+	s.Reset(now)
+	equalFloat(t, 10, s.Limit())
+	expect(t, !s.AttemptReserve(now.Add(time.Minute)), "expected reset to keep a pause")
+	expect(t, s.AttemptReserve(now.Add(time.Hour)), "expected requests once the pause is over")
+}